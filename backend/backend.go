@@ -0,0 +1,45 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+)
+
+// Backend provides access to the objects stored in a storage service, for both
+// the proxy and the map modes.
+type Backend interface {
+	// Serve writes the given object to w, honoring the method (GET or HEAD)
+	// and the conditional and range headers of r. Serve is responsible for
+	// writing the error response, the returned error is only meant for
+	// logging.
+	//
+	// Requests with the "generation" query string parameter must be served
+	// with that exact generation of the object, or fail with 404 when it
+	// doesn't exist anymore.
+	Serve(w http.ResponseWriter, r *http.Request, bucket, object string) error
+
+	// Bucket returns the handle used for listing the objects in the given
+	// bucket.
+	Bucket(name string) vodmodule.Bucket
+}
+
+// GenerationParam is the query string parameter that pins a request to a
+// generation of the object, as in the XML API of GCS.
+const GenerationParam = "generation"
+
+// requestedGeneration returns the generation requested in r, or zero when the
+// request isn't pinned to a generation.
+func requestedGeneration(r *http.Request) (int64, error) {
+	value := r.URL.Query().Get(GenerationParam)
+	if value == "" {
+		return 0, nil
+	}
+	generation, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || generation <= 0 {
+		return 0, fmt.Errorf("backend: invalid generation %q", value)
+	}
+	return generation, nil
+}
@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+)
+
+// testBucketNewRangeReader runs the tests for NewRangeReader that are shared
+// by all backends, reading objects from testhelper.FakeObjects.
+func testBucketNewRangeReader(t *testing.T, bucket vodmodule.Bucket) {
+	tests := []struct {
+		name     string
+		offset   int64
+		length   int64
+		expected string
+	}{
+		{"full object", 0, -1, "some nicer music"},
+		{"range", 5, 5, "nicer"},
+		{"open-ended range", 11, -1, "music"},
+	}
+	for _, test := range tests {
+		r, err := bucket.NewRangeReader(context.TODO(), "musics/music/music2.txt", 0, test.offset, test.length)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if string(data) != test.expected {
+			t.Errorf("%s: wrong content\nwant %q\ngot  %q", test.name, test.expected, data)
+		}
+	}
+	_, err := bucket.NewRangeReader(context.TODO(), "musics/music/some-music.txt", 0, 0, -1)
+	if err != storage.ErrObjectNotExist {
+		t.Errorf("wrong error returned\nwant %v\ngot  %v", storage.ErrObjectNotExist, err)
+	}
+}
@@ -0,0 +1,37 @@
+package backend
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// mediaTypes contains the content types of the media files handled by
+// nginx-vod-module, as they're often missing from the system MIME tables.
+var mediaTypes = map[string]string{
+	".aac":  "audio/aac",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".m4a":  "audio/mp4",
+	".m4s":  "video/iso.segment",
+	".m4v":  "video/mp4",
+	".mp3":  "audio/mpeg",
+	".mp4":  "video/mp4",
+	".mpd":  "application/dash+xml",
+	".srt":  "application/x-subrip",
+	".ts":   "video/mp2t",
+	".vtt":  "text/vtt",
+	".wav":  "audio/wav",
+}
+
+// ContentType returns the content type of the given object, based on its
+// extension.
+func ContentType(object string) string {
+	ext := strings.ToLower(path.Ext(object))
+	if ct, ok := mediaTypes[ext]; ok {
+		return ct
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
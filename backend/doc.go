@@ -0,0 +1,3 @@
+// Package backend defines the storage backends that gcs-helper can serve and
+// map objects from.
+package backend
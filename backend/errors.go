@@ -0,0 +1,14 @@
+package backend
+
+import "fmt"
+
+// StatusError is returned when a storage service responds with an unexpected
+// HTTP status.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("backend: unexpected status %d: %s", e.StatusCode, e.Message)
+}
@@ -0,0 +1,275 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+)
+
+// GCS is the Google Cloud Storage backend.
+//
+// By default, objects are proxied through the XML API using HTTPClient, which
+// must handle authentication, while listings are done with Client.
+type GCS struct {
+	Client     *storage.Client
+	HTTPClient *http.Client
+
+	// Endpoint is the base URL of the XML API, used when proxying requests.
+	// Defaults to https://storage.googleapis.com.
+	Endpoint string
+
+	// PathStyle sends the name of the bucket in the path of the request,
+	// instead of in the hostname.
+	PathStyle bool
+
+	// UseClient serves objects using Client instead of building requests to
+	// the XML API, so the endpoint configured in the client (including
+	// STORAGE_EMULATOR_HOST) is honored.
+	UseClient bool
+}
+
+// Serve writes the object to w, either by proxying the request to the XML
+// API or by reading it with the storage client.
+func (g *GCS) Serve(w http.ResponseWriter, r *http.Request, bucket, object string) error {
+	if g.UseClient {
+		return g.serveWithClient(w, r, bucket, object)
+	}
+	return g.proxy(w, r, bucket, object)
+}
+
+// proxy sends the request to the XML API, copying the headers from both the
+// request and the response. The query string is forwarded as is, so the XML
+// API handles the generation parameter.
+func (g *GCS) proxy(w http.ResponseWriter, r *http.Request, bucket, object string) error {
+	u, err := g.objectURL(bucket, object)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	u.RawQuery = r.URL.RawQuery
+	// no support for request body, do we care? :)
+	gcsReq, err := http.NewRequest(r.Method, u.String(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	gcsReq = gcsReq.WithContext(r.Context())
+	for name, values := range r.Header {
+		for _, value := range values {
+			gcsReq.Header.Add(name, value)
+		}
+	}
+	gcsResp, err := g.HTTPClient.Do(gcsReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	defer gcsResp.Body.Close()
+
+	for name, values := range gcsResp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(gcsResp.StatusCode)
+	io.Copy(w, gcsResp.Body)
+	return nil
+}
+
+func (g *GCS) objectURL(bucket, object string) (*url.URL, error) {
+	endpoint := g.Endpoint
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	path := "/" + object
+	if g.PathStyle {
+		path = "/" + bucket + path
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	return u, nil
+}
+
+// Bucket returns the listing handle for the given bucket.
+func (g *GCS) Bucket(name string) vodmodule.Bucket {
+	return vodmodule.GCSBucket(g.Client.Bucket(name))
+}
+
+// serveWithClient loads the attributes of the object and maps them onto the
+// response headers, then serves the content with http.ServeContent, which
+// handles range and conditional requests.
+func (g *GCS) serveWithClient(w http.ResponseWriter, r *http.Request, bucket, object string) error {
+	if g.Client == nil {
+		err := errors.New("backend: storage client not configured")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	generation, err := requestedGeneration(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	obj := g.Client.Bucket(bucket).Object(object)
+	if generation > 0 {
+		obj = obj.Generation(generation)
+	}
+	attrs, err := obj.Attrs(r.Context())
+	if err == storage.ErrObjectNotExist || err == storage.ErrBucketNotExist {
+		http.Error(w, "not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	setObjectHeaders(w.Header(), attrs)
+
+	// reads are pinned to the generation returned by Attrs, so the content
+	// always matches the headers.
+	obj = obj.ReadCompressed(true)
+	if attrs.Generation > 0 {
+		obj = obj.Generation(attrs.Generation)
+	}
+	content := &objectReader{ctx: r.Context(), obj: obj, size: attrs.Size}
+	content.start, content.end = singleRange(r.Header.Get("Range"), attrs.Size)
+	defer content.Close()
+	http.ServeContent(w, r, object, attrs.Updated, content)
+	return content.err
+}
+
+func setObjectHeaders(header http.Header, attrs *storage.ObjectAttrs) {
+	contentType := attrs.ContentType
+	if contentType == "" {
+		contentType = ContentType(attrs.Name)
+	}
+	header.Set("Content-Type", contentType)
+	if attrs.Etag != "" {
+		etag := attrs.Etag
+		if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, "W/") {
+			etag = strconv.Quote(etag)
+		}
+		header.Set("ETag", etag)
+	}
+	optional := map[string]string{
+		"Cache-Control":       attrs.CacheControl,
+		"Content-Encoding":    attrs.ContentEncoding,
+		"Content-Disposition": attrs.ContentDisposition,
+		"Content-Language":    attrs.ContentLanguage,
+	}
+	for name, value := range optional {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+	if attrs.Generation > 0 {
+		header.Set("X-Goog-Generation", strconv.FormatInt(attrs.Generation, 10))
+		header.Set("X-Goog-Metageneration", strconv.FormatInt(attrs.Metageneration, 10))
+	}
+	for name, value := range attrs.Metadata {
+		header.Set("X-Goog-Meta-"+name, value)
+	}
+}
+
+// singleRange parses a Range header with a single, bounded range. It returns
+// zeros for any other value, as the hint is only an optimization.
+func singleRange(value string, size int64) (start, end int64) {
+	if !strings.HasPrefix(value, "bytes=") || strings.Contains(value, ",") {
+		return 0, 0
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, "bytes="), "-", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return 0, 0
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil {
+		return 0, 0
+	}
+	last, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+	if err != nil || last < start || start >= size {
+		return 0, 0
+	}
+	if last >= size {
+		last = size - 1
+	}
+	return start, last + 1
+}
+
+// objectReader is an io.ReadSeeker on top of storage.ObjectHandle. Readers are
+// opened lazily, so seeking is free. When the first read starts at the
+// beginning of the requested range, the reader is limited to that range.
+type objectReader struct {
+	ctx        context.Context
+	obj        *storage.ObjectHandle
+	size       int64
+	offset     int64
+	start, end int64
+	r          *storage.Reader
+	err        error
+}
+
+func (o *objectReader) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.r == nil {
+		length := int64(-1)
+		if o.offset == o.start && o.end > o.start {
+			length = o.end - o.start
+		}
+		o.r, o.err = o.obj.NewRangeReader(o.ctx, o.offset, length)
+		if o.err != nil {
+			return 0, o.err
+		}
+	}
+	n, err := o.r.Read(p)
+	o.offset += int64(n)
+	if err == io.EOF && o.offset < o.size {
+		// the reader was limited to the requested range, but the caller
+		// wants more (e.g. a failed If-Range), so drop the hint and reopen.
+		o.Close()
+		o.end = 0
+		err = nil
+	}
+	if err != nil && err != io.EOF {
+		o.err = err
+	}
+	return n, err
+}
+
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("backend: negative position")
+	}
+	if offset != o.offset {
+		o.Close()
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *objectReader) Close() error {
+	if o.r == nil {
+		return nil
+	}
+	err := o.r.Close()
+	o.r = nil
+	return err
+}
@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestSingleRange(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end int64
+	}{
+		{"bytes=2-10", 2, 11},
+		{"bytes=2-100", 2, 16},
+		{"bytes=2-", 0, 0},
+		{"bytes=-5", 0, 0},
+		{"bytes=0-1,4-5", 0, 0},
+		{"bytes=20-30", 0, 0},
+		{"items=1-2", 0, 0},
+		{"", 0, 0},
+	}
+	for _, test := range tests {
+		start, end := singleRange(test.value, 16)
+		if start != test.start || end != test.end {
+			t.Errorf("singleRange(%q): want (%d, %d), got (%d, %d)", test.value, test.start, test.end, start, end)
+		}
+	}
+}
+
+func TestSetObjectHeaders(t *testing.T) {
+	header := http.Header{}
+	setObjectHeaders(header, &storage.ObjectAttrs{
+		Name:           "videos/video1_720p.mp4",
+		CacheControl:   "public, max-age=3600",
+		Etag:           "CKih16GjycICEAE=",
+		Generation:     1522162384154000,
+		Metageneration: 1,
+		Metadata:       map[string]string{"status": "ready"},
+		Updated:        time.Now(),
+	})
+	expected := http.Header{
+		"Content-Type":          []string{"video/mp4"},
+		"Cache-Control":         []string{"public, max-age=3600"},
+		"Etag":                  []string{`"CKih16GjycICEAE="`},
+		"X-Goog-Generation":     []string{"1522162384154000"},
+		"X-Goog-Metageneration": []string{"1"},
+		"X-Goog-Meta-Status":    []string{"ready"},
+	}
+	if !reflect.DeepEqual(header, expected) {
+		t.Errorf("wrong headers\nwant %#v\ngot  %#v", expected, header)
+	}
+}
+
+func TestGCSObjectURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		gcs      GCS
+		expected string
+	}{
+		{
+			"default endpoint",
+			GCS{},
+			"https://my-bucket.storage.googleapis.com/videos/video1_720p.mp4",
+		},
+		{
+			"default endpoint - path style",
+			GCS{PathStyle: true},
+			"https://storage.googleapis.com/my-bucket/videos/video1_720p.mp4",
+		},
+		{
+			"private endpoint",
+			GCS{Endpoint: "https://storage-gcshelper.p.googleapis.com"},
+			"https://my-bucket.storage-gcshelper.p.googleapis.com/videos/video1_720p.mp4",
+		},
+		{
+			"emulator",
+			GCS{Endpoint: "http://localhost:4443/", PathStyle: true},
+			"http://localhost:4443/my-bucket/videos/video1_720p.mp4",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			u, err := test.gcs.objectURL("my-bucket", "videos/video1_720p.mp4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.String() != test.expected {
+				t.Errorf("wrong url\nwant %q\ngot  %q", test.expected, u.String())
+			}
+		})
+	}
+}
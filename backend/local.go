@@ -0,0 +1,227 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+	"google.golang.org/api/iterator"
+)
+
+// Local is a backend that serves objects from a directory tree in the local
+// filesystem, where each directory directly under Root represents a bucket.
+//
+// It's meant for development, as it doesn't need any credentials.
+type Local struct {
+	Root string
+}
+
+// Serve writes the content of the file that represents the object. ETags are
+// derived from the modification time and the size of the file.
+//
+// Files have a single generation, given by their modification time, so
+// requests pinned to any other generation fail with 404.
+func (l *Local) Serve(w http.ResponseWriter, r *http.Request, bucket, object string) error {
+	generation, err := requestedGeneration(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	f, stat, err := l.open(bucket, object)
+	if err == nil && generation > 0 && generation != localGeneration(stat) {
+		f.Close()
+		err = os.ErrNotExist
+	}
+	if os.IsNotExist(err) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", ContentType(object))
+	w.Header().Set("ETag", localEtag(stat))
+	http.ServeContent(w, r, object, stat.ModTime(), f)
+	return nil
+}
+
+func (l *Local) open(bucket, object string) (*os.File, os.FileInfo, error) {
+	if !validBucketName(bucket) || object == "" {
+		return nil, nil, os.ErrNotExist
+	}
+	f, err := os.Open(filepath.Join(l.Root, bucket, filepath.FromSlash(path.Clean("/"+object))))
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := f.Stat()
+	if err == nil && stat.IsDir() {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, stat, nil
+}
+
+// Bucket returns the listing handle for the given bucket.
+func (l *Local) Bucket(name string) vodmodule.Bucket {
+	return localBucket{root: l.Root, name: name}
+}
+
+type localBucket struct {
+	root string
+	name string
+}
+
+// Objects lists the files in the bucket directory, following the same
+// semantics as GCS for the prefix and the delimiter.
+func (b localBucket) Objects(ctx context.Context, q *storage.Query) vodmodule.ObjectIterator {
+	if q == nil {
+		q = &storage.Query{}
+	}
+	objs, err := b.list(q)
+	return &sliceIterator{objs: objs, err: err}
+}
+
+// NewRangeReader opens the file that represents the object. As in Serve,
+// any generation other than the current one doesn't exist.
+func (b localBucket) NewRangeReader(ctx context.Context, object string, generation, offset, length int64) (io.ReadCloser, error) {
+	f, stat, err := (&Local{Root: b.root}).open(b.name, object)
+	if err == nil && generation > 0 && generation != localGeneration(stat) {
+		f.Close()
+		err = os.ErrNotExist
+	}
+	if os.IsNotExist(err) {
+		return nil, storage.ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (b localBucket) list(q *storage.Query) ([]*storage.ObjectAttrs, error) {
+	if !validBucketName(b.name) {
+		return nil, storage.ErrBucketNotExist
+	}
+	bucketDir := filepath.Join(b.root, b.name)
+	if stat, err := os.Stat(bucketDir); err != nil || !stat.IsDir() {
+		return nil, storage.ErrBucketNotExist
+	}
+	walkRoot := filepath.Join(bucketDir, filepath.FromSlash(q.Prefix[:strings.LastIndex(q.Prefix, "/")+1]))
+	// prefixes with ".." segments can't match objects, and must not list
+	// files outside of the bucket.
+	if walkRoot != bucketDir && !strings.HasPrefix(walkRoot, bucketDir+string(os.PathSeparator)) {
+		return nil, nil
+	}
+	files := map[string]os.FileInfo{}
+	err := filepath.Walk(walkRoot, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(bucketDir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if info.IsDir() {
+			if name != "." && !strings.HasPrefix(name+"/", q.Prefix) && !strings.HasPrefix(q.Prefix, name+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && strings.HasPrefix(name, q.Prefix) {
+			files[name] = info
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var objs []*storage.ObjectAttrs
+	var lastPrefix string
+	for _, name := range names {
+		if q.Delimiter != "" {
+			if i := strings.Index(name[len(q.Prefix):], q.Delimiter); i > -1 {
+				prefix := name[:len(q.Prefix)+i+len(q.Delimiter)]
+				if prefix != lastPrefix {
+					objs = append(objs, &storage.ObjectAttrs{Prefix: prefix})
+					lastPrefix = prefix
+				}
+				continue
+			}
+		}
+		info := files[name]
+		objs = append(objs, &storage.ObjectAttrs{
+			Bucket:         b.name,
+			Name:           name,
+			ContentType:    ContentType(name),
+			Size:           info.Size(),
+			Created:        info.ModTime(),
+			Updated:        info.ModTime(),
+			Generation:     localGeneration(info),
+			Metageneration: 1,
+			Etag:           localEtag(info),
+		})
+	}
+	return objs, nil
+}
+
+func validBucketName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+func localGeneration(info os.FileInfo) int64 {
+	return info.ModTime().UnixNano()
+}
+
+func localEtag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+type sliceIterator struct {
+	objs []*storage.ObjectAttrs
+	err  error
+}
+
+func (it *sliceIterator) Next() (*storage.ObjectAttrs, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	if len(it.objs) == 0 {
+		return nil, iterator.Done
+	}
+	obj := it.objs[0]
+	it.objs = it.objs[1:]
+	return obj, nil
+}
@@ -0,0 +1,282 @@
+package backend
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/internal/testhelper"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/iterator"
+)
+
+func TestLocalServe(t *testing.T) {
+	local, cleanup := testLocal(t)
+	defer cleanup()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		local.Serve(w, r, "my-bucket", r.URL.Path[1:])
+	}))
+	defer server.Close()
+
+	tests := []testhelper.ServerTest{
+		{
+			TestCase:       "download file",
+			Method:         http.MethodGet,
+			Addr:           server.URL + "/musics/music/music1.txt",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Accept-Ranges":  []string{"bytes"},
+				"Content-Length": []string{"15"},
+				"Content-Type":   []string{"text/plain; charset=utf-8"},
+			},
+			ExpectedBody: "some nice music",
+		},
+		{
+			TestCase: "download file - range",
+			Method:   http.MethodGet,
+			Addr:     server.URL + "/musics/music/music2.txt",
+			ReqHeader: http.Header{
+				"Range": []string{"bytes=2-10"},
+			},
+			ExpectedStatus: http.StatusPartialContent,
+			ExpectedHeader: http.Header{
+				"Content-Length": []string{"9"},
+				"Content-Range":  []string{"bytes 2-10/16"},
+			},
+			ExpectedBody: "me nicer ",
+		},
+		{
+			TestCase:       "media content type",
+			Method:         http.MethodHead,
+			Addr:           server.URL + "/videos/video/video1_480p.mp4",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{"Content-Type": []string{"video/mp4"}},
+		},
+		{
+			TestCase:       "object not found",
+			Method:         http.MethodGet,
+			Addr:           server.URL + "/musics/music/some-music.txt",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   "not found\n",
+		},
+		{
+			TestCase:       "directory",
+			Method:         http.MethodGet,
+			Addr:           server.URL + "/musics/music",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   "not found\n",
+		},
+		{
+			TestCase:       "path traversal",
+			Method:         http.MethodGet,
+			Addr:           server.URL + "/../your-bucket/musics/music/music3.txt",
+			ExpectedStatus: http.StatusNotFound,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.TestCase, test.Run)
+	}
+}
+
+func TestLocalServeETag(t *testing.T) {
+	local, cleanup := testLocal(t)
+	defer cleanup()
+	w := httptest.NewRecorder()
+	local.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), "my-bucket", "musics/music/music1.txt")
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("missing ETag header")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	local.Serve(w, req, "my-bucket", "musics/music/music1.txt")
+	if w.Code != http.StatusNotModified {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusNotModified, w.Code)
+	}
+}
+
+func TestLocalServeGeneration(t *testing.T) {
+	local, cleanup := testLocal(t)
+	defer cleanup()
+	obj, err := local.Bucket("my-bucket").Objects(context.Background(), &storage.Query{Prefix: "musics/music/music1.txt"}).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{"current generation", "?generation=" + strconv.FormatInt(obj.Generation, 10), http.StatusOK},
+		{"other generation", "?generation=" + strconv.FormatInt(obj.Generation-1, 10), http.StatusNotFound},
+		{"invalid generation", "?generation=latest", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		local.Serve(w, httptest.NewRequest(http.MethodGet, "/"+test.query, nil), "my-bucket", "musics/music/music1.txt")
+		if w.Code != test.expectedStatus {
+			t.Errorf("%s: wrong status code\nwant %d\ngot  %d", test.name, test.expectedStatus, w.Code)
+		}
+	}
+}
+
+func TestLocalObjects(t *testing.T) {
+	local, cleanup := testLocal(t)
+	defer cleanup()
+	tests := []struct {
+		name     string
+		bucket   string
+		query    storage.Query
+		expected []string
+	}{
+		{
+			"prefix and delimiter",
+			"my-bucket",
+			storage.Query{Prefix: "musics/music/", Delimiter: "/"},
+			[]string{
+				"musics/music/music/",
+				"musics/music/music1.txt",
+				"musics/music/music2.txt",
+				"musics/music/music3.txt",
+				"musics/music/music4.mp3",
+				"musics/music/music5.wav",
+			},
+		},
+		{
+			"partial prefix",
+			"my-bucket",
+			storage.Query{Prefix: "musics/music/music/3"},
+			[]string{"musics/music/music/3.txt"},
+		},
+		{
+			"no delimiter",
+			"my-bucket",
+			storage.Query{Prefix: "musics/"},
+			[]string{
+				"musics/music/music/1.txt",
+				"musics/music/music/2.txt",
+				"musics/music/music/3.txt",
+				"musics/music/music/4.mp3",
+				"musics/music/music1.txt",
+				"musics/music/music2.txt",
+				"musics/music/music3.txt",
+				"musics/music/music4.mp3",
+				"musics/music/music5.wav",
+				"musics/musics/music1.txt",
+			},
+		},
+		{
+			"missing prefix",
+			"my-bucket",
+			storage.Query{Prefix: "whatever/"},
+			nil,
+		},
+		{
+			"traversal to a sibling bucket",
+			"my-bucket",
+			storage.Query{Prefix: "../your-bucket/"},
+			nil,
+		},
+		{
+			"traversal within the prefix",
+			"my-bucket",
+			storage.Query{Prefix: "musics/../../your-bucket/musics/", Delimiter: "/"},
+			nil,
+		},
+		{
+			"traversal to the root",
+			"my-bucket",
+			storage.Query{Prefix: "../../"},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			iter := local.Bucket(test.bucket).Objects(context.TODO(), &test.query)
+			var names []string
+			obj, err := iter.Next()
+			for ; err == nil; obj, err = iter.Next() {
+				if obj.Prefix != "" {
+					names = append(names, obj.Prefix)
+				} else {
+					names = append(names, obj.Name)
+				}
+			}
+			if err != iterator.Done {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(names, test.expected); diff != "" {
+				t.Errorf("wrong list of objects returned\nwant %#v\ngot  %#v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestLocalObjectsAttrs(t *testing.T) {
+	local, cleanup := testLocal(t)
+	defer cleanup()
+	obj, err := local.Bucket("my-bucket").Objects(context.TODO(), &storage.Query{Prefix: "musics/music/music1"}).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Bucket != "my-bucket" || obj.Size != 15 || obj.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("wrong attrs returned: %#v", obj)
+	}
+	if obj.Generation == 0 || obj.Etag == "" {
+		t.Errorf("missing generation or etag: %#v", obj)
+	}
+}
+
+func TestLocalObjectsBucketNotFound(t *testing.T) {
+	local, cleanup := testLocal(t)
+	defer cleanup()
+	_, err := local.Bucket("some-bucket").Objects(context.TODO(), &storage.Query{}).Next()
+	if err != storage.ErrBucketNotExist {
+		t.Errorf("wrong error returned\nwant %v\ngot  %v", storage.ErrBucketNotExist, err)
+	}
+}
+
+func testLocal(t *testing.T) (*Local, func()) {
+	dir, err := ioutil.TempDir("", "gcs-helper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testhelper.WriteObjects(dir, testhelper.FakeObjects)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return &Local{Root: dir}, func() { os.RemoveAll(dir) }
+}
+
+func TestLocalNewRangeReader(t *testing.T) {
+	local, cleanup := testLocal(t)
+	defer cleanup()
+	testBucketNewRangeReader(t, local.Bucket("my-bucket"))
+}
+
+func TestLocalNewRangeReaderGeneration(t *testing.T) {
+	local, cleanup := testLocal(t)
+	defer cleanup()
+	bucket := local.Bucket("my-bucket")
+	obj, err := bucket.Objects(context.Background(), &storage.Query{Prefix: "musics/music/music2.txt"}).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := bucket.NewRangeReader(context.TODO(), obj.Name, obj.Generation, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	_, err = bucket.NewRangeReader(context.TODO(), obj.Name, obj.Generation-1, 0, -1)
+	if err != storage.ErrObjectNotExist {
+		t.Errorf("wrong error returned\nwant %v\ngot  %v", storage.ErrObjectNotExist, err)
+	}
+}
@@ -0,0 +1,284 @@
+package backend
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+	"google.golang.org/api/iterator"
+)
+
+const s3DefaultRegion = "us-east-1"
+
+// s3ForwardedHeaders is the list of request headers that are sent to S3 when
+// proxying objects. Other headers are dropped, as they could interfere with
+// the request signature.
+var s3ForwardedHeaders = []string{
+	"Range",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+}
+
+// S3 is a backend for S3-compatible storage services, like AWS S3 or MinIO.
+// Requests are signed with AWS Signature Version 4, unless AccessKeyID is
+// empty.
+type S3 struct {
+	// Endpoint is the base URL of the service. Defaults to the AWS endpoint
+	// of the region.
+	Endpoint string
+
+	// Region used in the request signature. Defaults to "us-east-1".
+	Region string
+
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// PathStyle sends the name of the bucket in the path of the request,
+	// instead of in the hostname. Most S3-compatible services other than AWS
+	// require path style.
+	PathStyle bool
+
+	HTTPClient *http.Client
+}
+
+// Serve proxies the request to the S3 service.
+//
+// S3 has no generations (listings don't report them, so clip paths are never
+// pinned), and the generation parameter is ignored.
+func (s *S3) Serve(w http.ResponseWriter, r *http.Request, bucket, object string) error {
+	req, err := s.newRequest(r.Context(), r.Method, bucket, object, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	for _, name := range s3ForwardedHeaders {
+		if value := r.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+	return nil
+}
+
+// Bucket returns the listing handle for the given bucket.
+func (s *S3) Bucket(name string) vodmodule.Bucket {
+	return s3Bucket{s3: s, name: name}
+}
+
+func (s *S3) region() string {
+	if s.Region == "" {
+		return s3DefaultRegion
+	}
+	return s.Region
+}
+
+func (s *S3) newRequest(ctx context.Context, method, bucket, object string, query url.Values) (*http.Request, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s.region() + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	path := "/" + object
+	if s.PathStyle {
+		path = "/" + bucket + path
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	u, err = u.Parse(strings.TrimSuffix(u.Path, "/") + awsEscape(path, false))
+	if err != nil {
+		return nil, err
+	}
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, awsEscape(name, true)+"="+awsEscape(query.Get(name), true))
+	}
+	u.RawQuery = canonicalQuery(strings.Join(params, "&"))
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return req.WithContext(ctx), nil
+}
+
+func (s *S3) do(req *http.Request) (*http.Response, error) {
+	if s.AccessKeyID != "" {
+		signer := sigV4{
+			accessKeyID:     s.AccessKeyID,
+			secretAccessKey: s.SecretAccessKey,
+			region:          s.region(),
+			service:         "s3",
+		}
+		signer.sign(req, time.Now())
+	}
+	hc := s.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return hc.Do(req)
+}
+
+type s3Bucket struct {
+	s3   *S3
+	name string
+}
+
+// Objects lists objects using the ListObjectsV2 API.
+func (b s3Bucket) Objects(ctx context.Context, q *storage.Query) vodmodule.ObjectIterator {
+	if q == nil {
+		q = &storage.Query{}
+	}
+	return &s3Iterator{ctx: ctx, bucket: b, query: *q}
+}
+
+// NewRangeReader reads the object with a ranged GET request. S3 listings
+// don't report generations, so only the live object can be read.
+func (b s3Bucket) NewRangeReader(ctx context.Context, object string, generation, offset, length int64) (io.ReadCloser, error) {
+	if generation > 0 {
+		return nil, storage.ErrObjectNotExist
+	}
+	if length == 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	req, err := b.s3.newRequest(ctx, http.MethodGet, b.name, object, nil)
+	if err != nil {
+		return nil, err
+	}
+	if length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := b.s3.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusNotFound {
+			if strings.Contains(string(data), "<Code>NoSuchBucket</Code>") {
+				return nil, storage.ErrBucketNotExist
+			}
+			return nil, storage.ErrObjectNotExist
+		}
+		return nil, &StatusError{StatusCode: resp.StatusCode, Message: string(data)}
+	}
+	return resp.Body, nil
+}
+
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+type s3Iterator struct {
+	ctx    context.Context
+	bucket s3Bucket
+	query  storage.Query
+	token  string
+	items  []*storage.ObjectAttrs
+	done   bool
+	err    error
+}
+
+func (it *s3Iterator) Next() (*storage.ObjectAttrs, error) {
+	for len(it.items) == 0 && !it.done && it.err == nil {
+		it.err = it.fetch()
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	if len(it.items) == 0 {
+		return nil, iterator.Done
+	}
+	obj := it.items[0]
+	it.items = it.items[1:]
+	return obj, nil
+}
+
+func (it *s3Iterator) fetch() error {
+	query := url.Values{"list-type": {"2"}}
+	if it.query.Prefix != "" {
+		query.Set("prefix", it.query.Prefix)
+	}
+	if it.query.Delimiter != "" {
+		query.Set("delimiter", it.query.Delimiter)
+	}
+	if it.token != "" {
+		query.Set("continuation-token", it.token)
+	}
+	req, err := it.bucket.s3.newRequest(it.ctx, http.MethodGet, it.bucket.name, "", query)
+	if err != nil {
+		return err
+	}
+	resp, err := it.bucket.s3.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusNotFound {
+			return storage.ErrBucketNotExist
+		}
+		return &StatusError{StatusCode: resp.StatusCode, Message: string(data)}
+	}
+	var result s3ListResult
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+	for _, obj := range result.Contents {
+		it.items = append(it.items, &storage.ObjectAttrs{
+			Bucket:       it.bucket.name,
+			Name:         obj.Key,
+			ContentType:  ContentType(obj.Key),
+			Size:         obj.Size,
+			Updated:      obj.LastModified,
+			Etag:         obj.ETag,
+			StorageClass: obj.StorageClass,
+		})
+	}
+	for _, prefix := range result.CommonPrefixes {
+		it.items = append(it.items, &storage.ObjectAttrs{Prefix: prefix.Prefix})
+	}
+	it.token = result.NextContinuationToken
+	it.done = !result.IsTruncated || it.token == ""
+	return nil
+}
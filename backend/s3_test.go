@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/internal/testhelper"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/iterator"
+)
+
+func TestS3Serve(t *testing.T) {
+	s3Server := testhelper.NewFakeS3(testhelper.FakeObjects)
+	defer s3Server.Close()
+	s3 := &S3{
+		Endpoint:        s3Server.URL,
+		AccessKeyID:     "access-key",
+		SecretAccessKey: "secret-key",
+		PathStyle:       true,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s3.Serve(w, r, "my-bucket", r.URL.Path[1:])
+	}))
+	defer server.Close()
+
+	tests := []testhelper.ServerTest{
+		{
+			TestCase:       "download file",
+			Method:         http.MethodGet,
+			Addr:           server.URL + "/musics/music/music1.txt",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Content-Length": []string{"15"},
+				"Etag":           []string{`"fake-etag"`},
+			},
+			ExpectedBody: "some nice music",
+		},
+		{
+			TestCase: "download file - range",
+			Method:   http.MethodGet,
+			Addr:     server.URL + "/musics/music/music2.txt",
+			ReqHeader: http.Header{
+				"Range": []string{"bytes=5-9"},
+			},
+			ExpectedStatus: http.StatusPartialContent,
+			ExpectedHeader: http.Header{
+				"Content-Range": []string{"bytes 5-9/16"},
+			},
+			ExpectedBody: "nicer",
+		},
+		{
+			TestCase:       "object not found",
+			Method:         http.MethodGet,
+			Addr:           server.URL + "/musics/music/some-music.txt",
+			ExpectedStatus: http.StatusNotFound,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.TestCase, test.Run)
+	}
+}
+
+func TestS3ServeUnsigned(t *testing.T) {
+	s3Server := testhelper.NewFakeS3(testhelper.FakeObjects)
+	defer s3Server.Close()
+	s3 := &S3{Endpoint: s3Server.URL, PathStyle: true}
+	w := httptest.NewRecorder()
+	s3.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), "my-bucket", "musics/music/music1.txt")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestS3Objects(t *testing.T) {
+	s3Server := testhelper.NewFakeS3(testhelper.FakeObjects)
+	defer s3Server.Close()
+	s3 := &S3{
+		Endpoint:        s3Server.URL,
+		AccessKeyID:     "access-key",
+		SecretAccessKey: "secret-key",
+		PathStyle:       true,
+	}
+	iter := s3.Bucket("my-bucket").Objects(context.TODO(), &storage.Query{Prefix: "musics/music/", Delimiter: "/"})
+	var names []string
+	obj, err := iter.Next()
+	for ; err == nil; obj, err = iter.Next() {
+		if obj.Prefix != "" {
+			names = append(names, obj.Prefix)
+		} else {
+			names = append(names, obj.Name)
+		}
+	}
+	if err != iterator.Done {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"musics/music/music1.txt",
+		"musics/music/music2.txt",
+		"musics/music/music3.txt",
+		"musics/music/music4.mp3",
+		"musics/music/music5.wav",
+		"musics/music/music/",
+	}
+	if diff := cmp.Diff(names, expected); diff != "" {
+		t.Errorf("wrong list of objects returned\nwant %#v\ngot  %#v", expected, names)
+	}
+}
+
+func TestS3ObjectsBucketNotFound(t *testing.T) {
+	s3Server := testhelper.NewFakeS3(testhelper.FakeObjects)
+	defer s3Server.Close()
+	s3 := &S3{Endpoint: s3Server.URL, AccessKeyID: "access-key", PathStyle: true}
+	_, err := s3.Bucket("some-bucket").Objects(context.TODO(), nil).Next()
+	if err != storage.ErrBucketNotExist {
+		t.Errorf("wrong error returned\nwant %v\ngot  %v", storage.ErrBucketNotExist, err)
+	}
+}
+
+func TestS3NewRangeReader(t *testing.T) {
+	s3Server := testhelper.NewFakeS3(testhelper.FakeObjects)
+	defer s3Server.Close()
+	s3 := &S3{Endpoint: s3Server.URL, AccessKeyID: "access-key", PathStyle: true}
+	testBucketNewRangeReader(t, s3.Bucket("my-bucket"))
+	_, err := s3.Bucket("some-bucket").NewRangeReader(context.TODO(), "musics/music/music2.txt", 0, 0, -1)
+	if err != storage.ErrBucketNotExist {
+		t.Errorf("wrong error returned\nwant %v\ngot  %v", storage.ErrBucketNotExist, err)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// invalidateResponse is the body of successful invalidation requests.
+type invalidateResponse struct {
+	Invalidated int `json:"invalidated"`
+}
+
+// AdminHandler returns the handler of the admin endpoint of the map mode,
+// which supports the following requests:
+//
+//   - POST /invalidate?bucket=<bucket>&prefix=<prefix>: removes the cached
+//     mappings of the prefix (see Invalidate). The bucket defaults to the
+//     configured bucket.
+//   - POST /pubsub: receives GCS notifications from Pub/Sub push
+//     subscriptions, invalidating the cached mappings and probes of the
+//     changed objects.
+//   - POST /batch: maps a list of prefixes concurrently, streaming the
+//     results as newline-delimited JSON (see serveBatch).
+//
+// Requests must send GCS_HELPER_MAP_ADMIN_TOKEN in the Authorization header,
+// as a bearer token, or in the "token" query string parameter, as supported by
+// push subscriptions. LoadConfig requires the token when the admin endpoint is
+// enabled; handlers built from other configurations without a token accept
+// any request.
+func (h *MapHandler) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if !h.authorized(r) {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		switch strings.Trim(r.URL.Path, "/") {
+		case "invalidate":
+			h.serveInvalidate(w, r)
+		case "pubsub":
+			h.servePubSub(w, r)
+		case "batch":
+			h.serveBatch(w, r)
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+		}
+	})
+}
+
+func (h *MapHandler) authorized(r *http.Request) bool {
+	token := h.config.Map.AdminToken
+	if token == "" {
+		return true
+	}
+	// push subscriptions with authentication send their own bearer
+	// token, so both are checked.
+	candidates := []string{r.URL.Query().Get("token")}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		candidates = append(candidates, strings.TrimPrefix(auth, "Bearer "))
+	}
+	for _, given := range candidates {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *MapHandler) serveInvalidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	bucket := query.Get("bucket")
+	if bucket == "" {
+		bucket = h.config.BucketName
+	}
+	prefix := strings.TrimLeft(query.Get("prefix"), "/")
+	if prefix == "" {
+		writeError(w, http.StatusBadRequest, "prefix cannot be empty")
+		return
+	}
+	n := h.Invalidate(bucket, prefix)
+	h.logger.WithFields(logrus.Fields{"prefix": prefix, "bucket": bucket, "invalidated": n}).Info("invalidated cached mappings")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invalidateResponse{Invalidated: n})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+)
+
+func TestMapHandlerCacheInvalidation(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	h := NewMapHandler(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map: MapConfig{
+			CacheTTL:   time.Hour,
+			AdminToken: "admin-token",
+		},
+	}, nil)
+	mapServer := httptest.NewServer(h)
+	defer mapServer.Close()
+	adminServer := httptest.NewServer(h.AdminHandler())
+	defer adminServer.Close()
+
+	invalidate := func(token string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, adminServer.URL+"/invalidate?prefix=videos/probed/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if n := testCountSequences(t, mapServer.URL+"/videos/probed/"); n != 4 {
+		t.Fatalf("wrong number of sequences\nwant 4\ngot  %d", n)
+	}
+	err := ioutil.WriteFile(filepath.Join(root, "my-bucket", "videos", "probed", "clip1_1080p.mp4"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := testCountSequences(t, mapServer.URL+"/videos/probed/"); n != 4 {
+		t.Errorf("mapping wasn't cached\nwant 4 sequences\ngot  %d", n)
+	}
+
+	resp := invalidate("wrong-token")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	resp = invalidate("admin-token")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	var body invalidateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Invalidated != 1 {
+		t.Errorf("wrong number of invalidated mappings\nwant 1\ngot  %d", body.Invalidated)
+	}
+	if n := testCountSequences(t, mapServer.URL+"/videos/probed/"); n != 5 {
+		t.Errorf("mapping wasn't invalidated\nwant 5 sequences\ngot  %d", n)
+	}
+}
+
+func TestMapHandlerAdminInvalidRequests(t *testing.T) {
+	t.Parallel()
+	h := NewMapHandler(Config{BucketName: "my-bucket"}, nil)
+	adminServer := httptest.NewServer(h.AdminHandler())
+	defer adminServer.Close()
+	tests := []struct {
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{http.MethodGet, "/invalidate?prefix=videos/", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/invalidate", http.StatusBadRequest},
+		{http.MethodPost, "/purge?prefix=videos/", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, adminServer.URL+test.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.expectedStatus {
+			t.Errorf("%s %s: wrong status code\nwant %d\ngot  %d", test.method, test.path, test.expectedStatus, resp.StatusCode)
+		}
+	}
+}
+
+func testCountSequences(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var m vodmodule.Mapping
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	return len(m.Sequences)
+}
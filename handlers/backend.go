@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/backend"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+)
+
+const (
+	backendGCS   = "gcs"
+	backendLocal = "local"
+	backendS3    = "s3"
+
+	proxyModeRaw    = "raw"
+	proxyModeClient = "client"
+)
+
+// NeedsGCS indicates whether any of the configured backends requires GCS
+// clients.
+func (c Config) NeedsGCS() bool {
+	for _, typ := range c.backendTypes() {
+		if typ == backendGCS {
+			return true
+		}
+	}
+	return false
+}
+
+// backendTypes returns the type of the default backend followed by the types
+// of the per-bucket backends.
+func (c Config) backendTypes() []string {
+	types := []string{c.defaultBackendType()}
+	for _, typ := range c.Backend.Buckets {
+		types = append(types, typ)
+	}
+	return types
+}
+
+func (c Config) defaultBackendType() string {
+	if c.Backend.Type == "" {
+		return backendGCS
+	}
+	return c.Backend.Type
+}
+
+func (c Config) newBackend(client *storage.Client, hc *http.Client) backend.Backend {
+	backends := bucketBackends{
+		defaultType: c.defaultBackendType(),
+		buckets:     c.Backend.Buckets,
+		byType:      map[string]backend.Backend{},
+	}
+	for _, typ := range c.backendTypes() {
+		switch typ {
+		case backendGCS:
+			backends.byType[typ] = &backend.GCS{
+				Client:     client,
+				HTTPClient: hc,
+				Endpoint:   c.Client.Endpoint,
+				PathStyle:  c.Client.PathStyle || c.Proxy.BucketOnPath,
+				UseClient:  c.Proxy.Mode == proxyModeClient,
+			}
+		case backendLocal:
+			backends.byType[typ] = &backend.Local{Root: c.Backend.LocalRoot}
+		case backendS3:
+			backends.byType[typ] = &backend.S3{
+				Endpoint:        c.Backend.S3.Endpoint,
+				Region:          c.Backend.S3.Region,
+				AccessKeyID:     c.Backend.S3.AccessKeyID,
+				SecretAccessKey: c.Backend.S3.SecretAccessKey,
+				PathStyle:       c.Backend.S3.PathStyle,
+				HTTPClient:      c.Client.plainHTTPClient(),
+			}
+		}
+	}
+	return &backends
+}
+
+// bucketBackends dispatches each bucket to the backend configured for it.
+type bucketBackends struct {
+	defaultType string
+	buckets     map[string]string
+	byType      map[string]backend.Backend
+}
+
+func (b *bucketBackends) forBucket(name string) backend.Backend {
+	if typ, ok := b.buckets[name]; ok {
+		return b.byType[typ]
+	}
+	return b.byType[b.defaultType]
+}
+
+func (b *bucketBackends) Serve(w http.ResponseWriter, r *http.Request, bucket, object string) error {
+	return b.forBucket(bucket).Serve(w, r, bucket, object)
+}
+
+func (b *bucketBackends) Bucket(name string) vodmodule.Bucket {
+	return b.forBucket(name).Bucket(name)
+}
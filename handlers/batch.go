@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBatchConcurrency = 8
+	maxBatchPrefixes        = 10000
+	maxBatchBodySize        = 4 << 20
+)
+
+// batchRequest is the body of batch requests. Prefixes are paths as accepted
+// by the map endpoint, and options are query string parameters applied to all
+// of them, with a list of values each, so parameters like "range" can be
+// repeated.
+type batchRequest struct {
+	Prefixes []string            `json:"prefixes"`
+	Options  map[string][]string `json:"options"`
+}
+
+// batchResult is a line of the response to batch requests, holding either
+// the mapping of a prefix or the error that prevented mapping it.
+type batchResult struct {
+	Prefix  string             `json:"prefix"`
+	Bucket  string             `json:"bucket,omitempty"`
+	Status  int                `json:"status"`
+	Mapping *vodmodule.Mapping `json:"mapping,omitempty"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// batchConcurrency returns the number of prefixes mapped concurrently by
+// batch requests.
+func (c MapConfig) batchConcurrency() int {
+	if c.BatchConcurrency <= 0 {
+		return defaultBatchConcurrency
+	}
+	return c.BatchConcurrency
+}
+
+// serveBatch maps the prefixes of the request with a bounded pool of workers,
+// streaming the results as newline-delimited JSON, in the order they
+// complete. Failures of individual prefixes are reported in their results,
+// so the status code only describes the batch request itself.
+func (h *MapHandler) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var body batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodySize)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid batch request: "+err.Error())
+		return
+	}
+	if len(body.Prefixes) == 0 {
+		writeError(w, http.StatusBadRequest, "prefixes cannot be empty")
+		return
+	}
+	if len(body.Prefixes) > maxBatchPrefixes {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("too many prefixes, the limit is %d", maxBatchPrefixes))
+		return
+	}
+	query := url.Values{}
+	for name, values := range body.Options {
+		query[name] = values
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		jobs    = make(chan string)
+		results = make(chan batchResult)
+		workers = h.config.Map.batchConcurrency()
+	)
+	if workers > len(body.Prefixes) {
+		workers = len(body.Prefixes)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for prefix := range jobs {
+				results <- h.batchMap(ctx, prefix, query)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, prefix := range body.Prefixes {
+			select {
+			case jobs <- prefix:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	var written, failed int
+	for result := range results {
+		if ctx.Err() != nil {
+			// the client is gone, so the remaining results are drained
+			// without writing.
+			continue
+		}
+		if err := encoder.Encode(result); err != nil {
+			cancel()
+			continue
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		written++
+		if result.Error != "" {
+			failed++
+		}
+	}
+	h.logger.WithFields(logrus.Fields{"prefixes": len(body.Prefixes), "written": written, "failed": failed}).Info("mapped batch")
+}
+
+// batchMap maps a prefix of a batch request.
+func (h *MapHandler) batchMap(ctx context.Context, prefix string, query url.Values) batchResult {
+	result := batchResult{Prefix: prefix}
+	req, err := h.parseRequest(prefix, query, nil)
+	if err == nil && req.format != formatJSON {
+		err = errors.New("batch requests only support JSON mappings")
+	}
+	if err != nil {
+		result.Status, result.Error = http.StatusBadRequest, err.Error()
+		return result
+	}
+	m, bucket, status, err := h.resolve(ctx, req)
+	result.Bucket, result.Status = bucket, status
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Mapping = &m
+	return result
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+)
+
+func TestMapHandlerBatch(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	h := NewMapHandler(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map: MapConfig{
+			RegexFilter:      `\.mp4$`,
+			EmptyNotFound:    true,
+			AdminToken:       "admin-token",
+			BatchConcurrency: 2,
+		},
+	}, nil)
+	adminServer := httptest.NewServer(h.AdminHandler())
+	defer adminServer.Close()
+
+	results := testBatch(t, adminServer.URL, `{"prefixes": ["videos/video/", "/videos/probed/", "videos/missing/", "videos/video/master.m3u8", "videos/parts/"]}`)
+	sort.Slice(results, func(i, j int) bool { return results[i].Prefix < results[j].Prefix })
+
+	expected := []struct {
+		prefix            string
+		status            int
+		expectedSequences int
+	}{
+		{"/videos/probed/", http.StatusOK, 4},
+		{"videos/missing/", http.StatusNotFound, 0},
+		{"videos/parts/", http.StatusOK, 4},
+		{"videos/video/", http.StatusOK, 3},
+		{"videos/video/master.m3u8", http.StatusBadRequest, 0},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("wrong number of results\nwant %d\ngot  %d: %#v", len(expected), len(results), results)
+	}
+	for i, result := range results {
+		want := expected[i]
+		if result.Prefix != want.prefix || result.Status != want.status {
+			t.Errorf("wrong result\nwant %s (%d)\ngot  %s (%d): %s", want.prefix, want.status, result.Prefix, result.Status, result.Error)
+			continue
+		}
+		if want.status != http.StatusOK {
+			if result.Error == "" || result.Mapping != nil {
+				t.Errorf("%s: expected an error without mapping, got %#v", result.Prefix, result)
+			}
+			continue
+		}
+		if result.Bucket != "my-bucket" {
+			t.Errorf("%s: wrong bucket %q", result.Prefix, result.Bucket)
+		}
+		if result.Mapping == nil || len(result.Mapping.Sequences) != want.expectedSequences {
+			t.Errorf("%s: wrong mapping\nwant %d sequences\ngot  %#v", result.Prefix, want.expectedSequences, result.Mapping)
+		}
+	}
+}
+
+func TestMapHandlerBatchOptions(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	h := NewMapHandler(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map: MapConfig{
+			FilterProfiles: FilterProfiles{"hd": {Include: `720p\.mp4$`}},
+		},
+	}, nil)
+	adminServer := httptest.NewServer(h.AdminHandler())
+	defer adminServer.Close()
+
+	results := testBatch(t, adminServer.URL, `{"prefixes": ["videos/probed/clip1_", "videos/probed/clip2_"], "options": {"profile": ["hd"]}}`)
+	if len(results) != 2 {
+		t.Fatalf("wrong number of results\nwant 2\ngot  %d", len(results))
+	}
+	for _, result := range results {
+		if result.Status != http.StatusOK || result.Mapping == nil || len(result.Mapping.Sequences) != 1 {
+			t.Errorf("%s: wrong result\nwant a single sequence\ngot  %#v", result.Prefix, result)
+		}
+	}
+}
+
+func TestMapHandlerBatchRepeatedOptions(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	h := NewMapHandler(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+	}, nil)
+	adminServer := httptest.NewServer(h.AdminHandler())
+	defer adminServer.Close()
+
+	results := testBatch(t, adminServer.URL, `{"prefixes": ["videos/probed/clip2_"], "options": {"range": ["0:1000-2000", "1:3000-4000"]}}`)
+	if len(results) != 1 || results[0].Status != http.StatusOK || results[0].Mapping == nil {
+		t.Fatalf("wrong results\nwant a single mapping\ngot  %#v", results)
+	}
+	var clips []vodmodule.Clip
+	for _, seq := range results[0].Mapping.Sequences {
+		clips = append(clips, seq.Clips...)
+	}
+	expectedClips := []vodmodule.Clip{
+		{Type: "source", Path: "/my-bucket/videos/probed/clip2_480p.mp4", ClipFrom: 1000, ClipTo: 2000},
+		{Type: "source", Path: "/my-bucket/videos/probed/clip2_720p.mp4", ClipFrom: 3000, ClipTo: 4000},
+	}
+	if !reflect.DeepEqual(clips, expectedClips) {
+		t.Errorf("wrong clips returned\nwant %#v\ngot  %#v", expectedClips, clips)
+	}
+}
+
+func TestMapHandlerBatchInvalidRequests(t *testing.T) {
+	t.Parallel()
+	h := NewMapHandler(Config{BucketName: "my-bucket", Map: MapConfig{CacheTTL: time.Hour}}, nil)
+	adminServer := httptest.NewServer(h.AdminHandler())
+	defer adminServer.Close()
+	tooMany := `{"prefixes": ["videos/"` + strings.Repeat(`, "videos/"`, maxBatchPrefixes) + `]}`
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+	}{
+		{"method not allowed", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"invalid JSON", http.MethodPost, `{"prefixes":`, http.StatusBadRequest},
+		{"no prefixes", http.MethodPost, `{"prefixes": []}`, http.StatusBadRequest},
+		{"too many prefixes", http.MethodPost, tooMany, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, adminServer.URL+"/batch", strings.NewReader(test.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.expectedStatus {
+			t.Errorf("%s: wrong status code\nwant %d\ngot  %d", test.name, test.expectedStatus, resp.StatusCode)
+		}
+	}
+}
+
+func testBatch(t *testing.T, addr, body string) []batchResult {
+	req, _ := http.NewRequest(http.MethodPost, addr+"/batch", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("wrong content type %q", contentType)
+	}
+	var results []batchResult
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var result batchResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		results = append(results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
@@ -2,11 +2,20 @@ package handlers
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/api/option"
@@ -16,9 +25,11 @@ import (
 // Config represents the gcs-helper configuration that is loaded from the
 // environment.
 type Config struct {
-	Listen     string `default:":8080"`
-	BucketName string `envconfig:"BUCKET_NAME" required:"true"`
-	LogLevel   string `envconfig:"LOG_LEVEL" default:"debug"`
+	Listen     string           `default:":8080"`
+	BucketName string           `envconfig:"BUCKET_NAME" required:"true"`
+	LogLevel   string           `envconfig:"LOG_LEVEL" default:"debug"`
+	Fallbacks  []FallbackBucket `envconfig:"FALLBACK_BUCKETS"`
+	Backend    BackendConfig
 	Client     ClientConfig
 	Map        MapConfig
 	Proxy      ProxyConfig
@@ -40,6 +51,209 @@ func (c Config) Logger() *logrus.Logger {
 type MapConfig struct {
 	Endpoint    string `envconfig:"GCS_HELPER_MAP_PREFIX"`
 	RegexFilter string `envconfig:"GCS_HELPER_MAP_REGEX_FILTER"`
+
+	// FilterProfiles are named filters that can be selected per request,
+	// in the path ("/map/<profile>/<prefix>") or in the "profile" query
+	// string parameter.
+	FilterProfiles FilterProfiles `envconfig:"GCS_HELPER_MAP_FILTER_PROFILES"`
+
+	// EmptyNotFound returns "404 Not Found" for prefixes without any
+	// mapped objects, instead of a mapping without sequences.
+	EmptyNotFound bool `envconfig:"GCS_HELPER_MAP_EMPTY_NOT_FOUND"`
+
+	// MetadataFilters only maps objects whose custom metadata match all the
+	// filters, in the formats "key=value", "key~regexp" and "key" (see
+	// vodmodule.ParseMetadataFilter). It's only supported when all buckets
+	// use the GCS backend.
+	MetadataFilters []string `envconfig:"GCS_HELPER_MAP_METADATA_FILTERS"`
+
+	// ContentTypes only maps objects with one of the given content types
+	// (like "video/*").
+	ContentTypes []string `envconfig:"GCS_HELPER_MAP_CONTENT_TYPES"`
+
+	// NamingPattern and NamingTemplate define how the id, language and
+	// label of sequences are extracted from file names. NamingPattern is a
+	// regular expression with named groups, while NamingTemplate uses
+	// placeholders (see vodmodule.CompileNamingTemplate). They're mutually
+	// exclusive.
+	NamingPattern  string `envconfig:"GCS_HELPER_MAP_NAMING_PATTERN"`
+	NamingTemplate string `envconfig:"GCS_HELPER_MAP_NAMING_TEMPLATE"`
+
+	// Languages maps custom language codes found in file names to
+	// ISO-639 codes, extending the built-in language table.
+	Languages map[string]string `envconfig:"GCS_HELPER_MAP_LANGUAGES"`
+
+	// SortBy defines the order of sequences: "lexical" (the default),
+	// "natural" or the name of a numeric group captured by the naming
+	// convention (like "height" or "bitrate").
+	SortBy         string `envconfig:"GCS_HELPER_MAP_SORT_BY"`
+	SortDescending bool   `envconfig:"GCS_HELPER_MAP_SORT_DESC"`
+
+	// MediaFirst places audio and video sequences before captions.
+	MediaFirst bool `envconfig:"GCS_HELPER_MAP_MEDIA_FIRST"`
+
+	// Recursive includes objects in subdirectories of the prefix, up to
+	// MaxDepth levels (0 means no limit).
+	Recursive bool `envconfig:"GCS_HELPER_MAP_RECURSIVE"`
+	MaxDepth  int  `envconfig:"GCS_HELPER_MAP_MAX_DEPTH"`
+
+	// Manifest is the name of the manifest object that may be stored in
+	// the prefix (like "mapping.json"). Manifests are disabled when empty.
+	Manifest string `envconfig:"GCS_HELPER_MAP_MANIFEST"`
+
+	// ClipPath is the template for the paths of clips, with the
+	// placeholders {bucket}, {object}, {generation}, {proxy} (the proxy
+	// prefix) and {token} (see vodmodule.ClipPathTemplate). Defaults to
+	// "/{bucket}/{object}".
+	ClipPath string `envconfig:"GCS_HELPER_MAP_CLIP_PATH"`
+
+	// TokenSecret and TokenTTL configure the tokens used by the {token}
+	// placeholder in ClipPath. When TokenSecret is set, ClipPath must send
+	// the token in the "token" query string parameter, and the proxy mode
+	// rejects requests without a valid token.
+	TokenSecret string        `envconfig:"GCS_HELPER_MAP_TOKEN_SECRET"`
+	TokenTTL    time.Duration `envconfig:"GCS_HELPER_MAP_TOKEN_TTL"`
+
+	// PinGeneration adds the generation of objects to the paths of clips,
+	// so the proxy keeps serving the same generation when objects are
+	// replaced during playback.
+	PinGeneration bool `envconfig:"GCS_HELPER_MAP_PIN_GENERATION"`
+
+	// HLSVariantURL is the template of the URLs of media playlists in HLS
+	// master playlists (see vodmodule.HLSOptions). Defaults to
+	// "{path}/index.m3u8".
+	HLSVariantURL string `envconfig:"GCS_HELPER_MAP_HLS_VARIANT_URL"`
+
+	// NegotiateFormat selects the output format with the Accept header
+	// when requests don't name one in the path or in the query string.
+	// It's disabled by default, as nginx-vod-module may forward the
+	// Accept header of players when requesting JSON mappings.
+	NegotiateFormat bool `envconfig:"GCS_HELPER_MAP_NEGOTIATE_FORMAT"`
+
+	// CacheTTL enables the cache of mappings, keeping them for the given
+	// duration. Mappings without sequences and missing prefixes are kept
+	// for CacheNegativeTTL, and the cache holds up to CacheSize mappings.
+	CacheTTL         time.Duration `envconfig:"GCS_HELPER_MAP_CACHE_TTL"`
+	CacheNegativeTTL time.Duration `envconfig:"GCS_HELPER_MAP_CACHE_NEGATIVE_TTL"`
+	CacheSize        int           `envconfig:"GCS_HELPER_MAP_CACHE_SIZE"`
+
+	// AdminEndpoint is the prefix of the admin endpoint, used to
+	// invalidate cached mappings and to map prefixes in batches. It's
+	// disabled when empty, and requires AdminToken, which requests must
+	// send as a bearer token.
+	AdminEndpoint string `envconfig:"GCS_HELPER_MAP_ADMIN_PREFIX"`
+	AdminToken    string `envconfig:"GCS_HELPER_MAP_ADMIN_TOKEN"`
+
+	// BatchConcurrency is the number of prefixes mapped concurrently by
+	// batch requests to the admin endpoint. Defaults to 8.
+	BatchConcurrency int `envconfig:"GCS_HELPER_MAP_BATCH_CONCURRENCY"`
+
+	// Probe reads the metadata of MP4 files to fill the durations of clips
+	// and the metadata of sequences. Results are cached by object
+	// generation, up to ProbeCacheSize entries.
+	Probe          bool `envconfig:"GCS_HELPER_MAP_PROBE"`
+	ProbeCacheSize int  `envconfig:"GCS_HELPER_MAP_PROBE_CACHE_SIZE"`
+}
+
+func (c MapConfig) ordering() vodmodule.Ordering {
+	return vodmodule.Ordering{
+		By:         c.SortBy,
+		Descending: c.SortDescending,
+		MediaFirst: c.MediaFirst,
+	}
+}
+
+// validateSortBy ensures that the group used for sorting is captured by
+// either the naming convention or the filter.
+func (c MapConfig) validateSortBy(naming *vodmodule.NamingConvention, filter *regexp.Regexp) error {
+	switch c.SortBy {
+	case "", vodmodule.SortLexical, vodmodule.SortNatural:
+		return nil
+	}
+	patterns := []*regexp.Regexp{filter}
+	if naming != nil {
+		patterns = append(patterns, naming.Pattern)
+	}
+	for _, pattern := range patterns {
+		for _, name := range pattern.SubexpNames() {
+			if name == c.SortBy {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("invalid sort mode %q: not a group in the naming convention", c.SortBy)
+}
+
+// namingConvention returns the configured naming convention, or nil when
+// there's none.
+func (c MapConfig) namingConvention() (*vodmodule.NamingConvention, error) {
+	if c.NamingPattern != "" && c.NamingTemplate != "" {
+		return nil, errors.New("GCS_HELPER_MAP_NAMING_PATTERN and GCS_HELPER_MAP_NAMING_TEMPLATE are mutually exclusive")
+	}
+	var (
+		pattern *regexp.Regexp
+		err     error
+	)
+	switch {
+	case c.NamingPattern != "":
+		pattern, err = regexp.Compile(c.NamingPattern)
+	case c.NamingTemplate != "":
+		pattern, err = vodmodule.CompileNamingTemplate(c.NamingTemplate)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	naming := vodmodule.NamingConvention{Pattern: pattern, Languages: map[string]vodmodule.Language{}}
+	for name, code := range c.Languages {
+		lang, ok := naming.LookupLanguage(code)
+		if !ok {
+			return nil, fmt.Errorf("invalid language code %q for %q", code, name)
+		}
+		naming.Languages[strings.ToLower(name)] = lang
+	}
+	return &naming, nil
+}
+
+// metadataFilters parses the configured metadata filters.
+func (c MapConfig) metadataFilters() ([]vodmodule.MetadataFilter, error) {
+	var filters []vodmodule.MetadataFilter
+	for _, value := range c.MetadataFilters {
+		f, err := vodmodule.ParseMetadataFilter(value)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// tokenSigner returns the signer of the tokens in clip paths, or nil when
+// there's no secret.
+func (c MapConfig) tokenSigner() *vodmodule.TokenSigner {
+	if c.TokenSecret == "" {
+		return nil
+	}
+	return &vodmodule.TokenSigner{Secret: []byte(c.TokenSecret), TTL: c.TokenTTL}
+}
+
+// clipPath returns the configured template for clip paths, or nil when the
+// default should be used.
+func (c Config) clipPath() (*vodmodule.ClipPathTemplate, error) {
+	if c.Map.ClipPath == "" && !c.Map.PinGeneration {
+		return nil, nil
+	}
+	template := vodmodule.ClipPathTemplate{
+		Template:      c.Map.ClipPath,
+		Proxy:         c.Proxy.Endpoint,
+		PinGeneration: c.Map.PinGeneration,
+	}
+	template.Signer = c.Map.tokenSigner()
+	if err := template.Validate(); err != nil {
+		return nil, err
+	}
+	return &template, nil
 }
 
 // ProxyConfig contains configuration for the proxy mode.
@@ -48,15 +262,62 @@ type ProxyConfig struct {
 	LogHeaders   []string      `envconfig:"GCS_HELPER_PROXY_LOG_HEADERS"`
 	Timeout      time.Duration `envconfig:"GCS_HELPER_PROXY_TIMEOUT" default:"10s"`
 	BucketOnPath bool          `envconfig:"GCS_HELPER_PROXY_BUCKET_ON_PATH"`
+
+	// Mode defines how objects are read from GCS: "raw" (the default)
+	// proxies requests to the XML API, while "client" reads objects with the
+	// storage client.
+	Mode string `envconfig:"GCS_HELPER_PROXY_MODE"`
+}
+
+// BackendConfig contains configuration for the storage backends.
+type BackendConfig struct {
+	// Type is the default backend: "gcs" (the default), "local" or "s3".
+	Type string `envconfig:"GCS_HELPER_BACKEND"`
+
+	// Buckets overrides the backend for specific buckets, mapping the name
+	// of the bucket to the type of the backend.
+	Buckets map[string]string `envconfig:"GCS_HELPER_BUCKET_BACKENDS"`
+
+	// LocalRoot is the directory used by the local backend. Each of its
+	// subdirectories is handled as a bucket.
+	LocalRoot string `envconfig:"GCS_HELPER_LOCAL_ROOT"`
+
+	S3 S3Config
+}
+
+// S3Config contains configuration for the S3-compatible backend.
+type S3Config struct {
+	Endpoint        string `envconfig:"GCS_HELPER_S3_ENDPOINT"`
+	Region          string `envconfig:"GCS_HELPER_S3_REGION"`
+	AccessKeyID     string `envconfig:"GCS_HELPER_S3_ACCESS_KEY_ID"`
+	SecretAccessKey string `envconfig:"GCS_HELPER_S3_SECRET_ACCESS_KEY"`
+	PathStyle       bool   `envconfig:"GCS_HELPER_S3_PATH_STYLE"`
 }
 
 // ClientConfig contains configuration for the GCS client communication.
 //
-// It contains options related to timeouts and keep-alive connections.
+// It contains options related to timeouts and keep-alive connections, along
+// with the upstream endpoint, which allows using emulators (like
+// fake-gcs-server), Private Service Connect or regional endpoints.
 type ClientConfig struct {
 	Timeout         time.Duration `envconfig:"GCS_CLIENT_TIMEOUT" default:"2s"`
 	IdleConnTimeout time.Duration `envconfig:"GCS_CLIENT_IDLE_CONN_TIMEOUT" default:"120s"`
 	MaxIdleConns    int           `envconfig:"GCS_CLIENT_MAX_IDLE_CONNS" default:"10"`
+
+	// Endpoint is the base URL (scheme and host) of the GCS API. Defaults
+	// to https://storage.googleapis.com.
+	Endpoint string `envconfig:"GCS_CLIENT_ENDPOINT"`
+
+	// PathStyle sends the bucket name in the path of proxied requests
+	// instead of in the hostname.
+	PathStyle bool `envconfig:"GCS_CLIENT_PATH_STYLE"`
+
+	// CABundle is the path to a PEM file with additional root certificates
+	// to trust when connecting to the endpoint.
+	CABundle string `envconfig:"GCS_CLIENT_CA_BUNDLE"`
+
+	// Anonymous disables authentication, which is useful for emulators.
+	Anonymous bool `envconfig:"GCS_CLIENT_ANONYMOUS"`
 }
 
 // HTTPClient returns an HTTP client with the proper authentication config
@@ -66,16 +327,158 @@ func (c ClientConfig) HTTPClient() (*http.Client, error) {
 		IdleConnTimeout: c.IdleConnTimeout,
 		MaxIdleConns:    c.MaxIdleConns,
 	}
-	transport, err := ghttp.NewTransport(context.Background(), &baseTransport, option.WithScopes(storage.ScopeReadOnly))
+	if c.CABundle != "" {
+		rootCAs, err := c.rootCAs()
+		if err != nil {
+			return nil, err
+		}
+		baseTransport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	}
+	opts := []option.ClientOption{option.WithScopes(storage.ScopeReadOnly)}
+	if c.Anonymous {
+		opts = append(opts, option.WithoutAuthentication())
+	}
+	transport, err := ghttp.NewTransport(context.Background(), &baseTransport, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if c.Endpoint != "" {
+		endpoint, err := url.Parse(c.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		transport = &endpointTransport{endpoint: endpoint, base: transport}
+	}
 	return &http.Client{
 		Timeout:   c.Timeout,
 		Transport: transport,
-	}, err
+	}, nil
+}
+
+// StorageClient returns a storage client that uses the given HTTP client and
+// the configured endpoint.
+func (c ClientConfig) StorageClient(ctx context.Context, hc *http.Client) (*storage.Client, error) {
+	opts := []option.ClientOption{option.WithHTTPClient(hc)}
+	if c.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(strings.TrimSuffix(c.Endpoint, "/")+"/storage/v1/"))
+	}
+	return storage.NewClient(ctx, opts...)
+}
+
+func (c ClientConfig) rootCAs() (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	data, err := ioutil.ReadFile(c.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", c.CABundle)
+	}
+	return pool, nil
+}
+
+// endpointTransport fixes the scheme of requests sent to the configured
+// endpoint, as the storage client always reads objects over https unless
+// STORAGE_EMULATOR_HOST is set.
+type endpointTransport struct {
+	endpoint *url.URL
+	base     http.RoundTripper
+}
+
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == t.endpoint.Host && req.URL.Scheme != t.endpoint.Scheme {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = t.endpoint.Scheme
+	}
+	return t.base.RoundTrip(req)
+}
+
+// plainHTTPClient returns an HTTP client with the same timeouts as
+// HTTPClient, but without Google's authentication.
+func (c ClientConfig) plainHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: c.Timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			IdleConnTimeout: c.IdleConnTimeout,
+			MaxIdleConns:    c.MaxIdleConns,
+		},
+	}
 }
 
 // LoadConfig loads the configuration from environment variables.
 func LoadConfig() (Config, error) {
 	var c Config
 	err := envconfig.Process("gcs_helper", &c)
+	if err == nil {
+		err = c.validate()
+	}
 	return c, err
 }
+
+func (c Config) validate() error {
+	switch c.Proxy.Mode {
+	case "", proxyModeRaw, proxyModeClient:
+	default:
+		return fmt.Errorf("invalid proxy mode %q", c.Proxy.Mode)
+	}
+	for _, typ := range c.backendTypes() {
+		switch typ {
+		case backendGCS, backendS3:
+		case backendLocal:
+			if c.Backend.LocalRoot == "" {
+				return errors.New("GCS_HELPER_LOCAL_ROOT is required by the local backend")
+			}
+		default:
+			return fmt.Errorf("invalid backend %q", typ)
+		}
+	}
+	if c.Map.AdminEndpoint != "" && c.Map.AdminToken == "" {
+		return errors.New("GCS_HELPER_MAP_ADMIN_TOKEN is required by the admin endpoint")
+	}
+	filter, err := regexp.Compile(c.Map.RegexFilter)
+	if err != nil {
+		return fmt.Errorf("invalid GCS_HELPER_MAP_REGEX_FILTER: %v", err)
+	}
+	naming, err := c.Map.namingConvention()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Map.metadataFilters(); err != nil {
+		return err
+	}
+	for _, typ := range c.backendTypes() {
+		// only GCS listings include custom metadata, so filters would
+		// silently drop every object in other backends.
+		if len(c.Map.MetadataFilters) > 0 && typ != backendGCS {
+			return fmt.Errorf("GCS_HELPER_MAP_METADATA_FILTERS isn't supported by the %s backend", typ)
+		}
+	}
+	if _, err := c.Map.FilterProfiles.compile(); err != nil {
+		return err
+	}
+	if _, err := c.clipPath(); err != nil {
+		return err
+	}
+	if c.Map.TokenSecret != "" && c.Map.CacheTTL > 0 {
+		tokenTTL := c.Map.TokenTTL
+		if tokenTTL <= 0 {
+			tokenTTL = vodmodule.DefaultTokenTTL
+		}
+		// cached mappings hold signed clip paths, which would be served
+		// after their tokens expire.
+		if c.Map.CacheTTL >= tokenTTL {
+			return fmt.Errorf("GCS_HELPER_MAP_CACHE_TTL (%s) must be shorter than GCS_HELPER_MAP_TOKEN_TTL (%s)", c.Map.CacheTTL, tokenTTL)
+		}
+	}
+	if c.Map.TokenSecret != "" && !strings.Contains(c.Map.ClipPath, tokenParam+"={token}") {
+		return fmt.Errorf("GCS_HELPER_MAP_TOKEN_SECRET requires GCS_HELPER_MAP_CLIP_PATH to send the token in the %q parameter, as in ?%s={token}", tokenParam, tokenParam)
+	}
+	if err := c.Map.hlsOptions().Validate(); err != nil {
+		return err
+	}
+	return c.Map.validateSortBy(naming, filter)
+}
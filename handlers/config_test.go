@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -12,18 +16,55 @@ import (
 
 func TestLoadConfig(t *testing.T) {
 	setEnvs(map[string]string{
-		"GCS_HELPER_LISTEN":               "0.0.0.0:3030",
-		"GCS_HELPER_BUCKET_NAME":          "some-bucket",
-		"GCS_HELPER_LOG_LEVEL":            "info",
-		"GCS_HELPER_MAP_PREFIX":           "/map/",
-		"GCS_HELPER_MAP_REGEX_FILTER":     `(240|360|424|480|720|1080)p\.(mp4|vtt|srt)$`,
-		"GCS_HELPER_PROXY_PREFIX":         "/proxy/",
-		"GCS_HELPER_PROXY_LOG_HEADERS":    "Accept,Range",
-		"GCS_HELPER_PROXY_TIMEOUT":        "20s",
-		"GCS_HELPER_PROXY_BUCKET_ON_PATH": "true",
-		"GCS_CLIENT_TIMEOUT":              "60s",
-		"GCS_CLIENT_IDLE_CONN_TIMEOUT":    "3m",
-		"GCS_CLIENT_MAX_IDLE_CONNS":       "16",
+		"GCS_HELPER_LISTEN":                 "0.0.0.0:3030",
+		"GCS_HELPER_BUCKET_NAME":            "some-bucket",
+		"GCS_HELPER_LOG_LEVEL":              "info",
+		"GCS_HELPER_FALLBACK_BUCKETS":       "old-bucket,older-bucket:videos/=media/videos/",
+		"GCS_HELPER_MAP_PREFIX":             "/map/",
+		"GCS_HELPER_MAP_REGEX_FILTER":       `(240|360|424|480|720|1080)p\.(mp4|vtt|srt)$`,
+		"GCS_HELPER_MAP_EMPTY_NOT_FOUND":    "true",
+		"GCS_HELPER_MAP_METADATA_FILTERS":   "status=ready,language~^(es|en)$",
+		"GCS_HELPER_MAP_FILTER_PROFILES":    `{"mobile": {"include": "(240|360)p\\.mp4$"}, "captions-only": {"include": "\\.vtt$", "exclude": "_forced"}}`,
+		"GCS_HELPER_MAP_CONTENT_TYPES":      "video/*,text/vtt",
+		"GCS_HELPER_MAP_NAMING_TEMPLATE":    "{id}_{*}_caption_{lang}_{*}.vtt",
+		"GCS_HELPER_MAP_LANGUAGES":          "wg:en,pb:pt",
+		"GCS_HELPER_MAP_SORT_BY":            "natural",
+		"GCS_HELPER_MAP_SORT_DESC":          "true",
+		"GCS_HELPER_MAP_MEDIA_FIRST":        "true",
+		"GCS_HELPER_MAP_RECURSIVE":          "true",
+		"GCS_HELPER_MAP_MAX_DEPTH":          "2",
+		"GCS_HELPER_MAP_MANIFEST":           "mapping.json",
+		"GCS_HELPER_MAP_CLIP_PATH":          "{proxy}{bucket}/{object}?token={token}",
+		"GCS_HELPER_MAP_TOKEN_SECRET":       "token-secret",
+		"GCS_HELPER_MAP_TOKEN_TTL":          "30m",
+		"GCS_HELPER_MAP_PIN_GENERATION":     "true",
+		"GCS_HELPER_MAP_HLS_VARIANT_URL":    "/hls{path}/index.m3u8",
+		"GCS_HELPER_MAP_NEGOTIATE_FORMAT":   "true",
+		"GCS_HELPER_MAP_CACHE_TTL":          "5m",
+		"GCS_HELPER_MAP_CACHE_NEGATIVE_TTL": "10s",
+		"GCS_HELPER_MAP_CACHE_SIZE":         "2000",
+		"GCS_HELPER_MAP_ADMIN_PREFIX":       "/admin/",
+		"GCS_HELPER_MAP_ADMIN_TOKEN":        "admin-token",
+		"GCS_HELPER_MAP_BATCH_CONCURRENCY":  "16",
+		"GCS_HELPER_MAP_PROBE":              "true",
+		"GCS_HELPER_MAP_PROBE_CACHE_SIZE":   "500",
+		"GCS_HELPER_PROXY_PREFIX":           "/proxy/",
+		"GCS_HELPER_PROXY_LOG_HEADERS":      "Accept,Range",
+		"GCS_HELPER_PROXY_TIMEOUT":          "20s",
+		"GCS_HELPER_PROXY_BUCKET_ON_PATH":   "true",
+		"GCS_HELPER_BUCKET_BACKENDS":        "media:gcs",
+		"GCS_HELPER_S3_ENDPOINT":            "http://localhost:9000",
+		"GCS_HELPER_S3_REGION":              "sa-east-1",
+		"GCS_HELPER_S3_ACCESS_KEY_ID":       "access-key",
+		"GCS_HELPER_S3_SECRET_ACCESS_KEY":   "secret-key",
+		"GCS_HELPER_S3_PATH_STYLE":          "true",
+		"GCS_CLIENT_TIMEOUT":                "60s",
+		"GCS_CLIENT_IDLE_CONN_TIMEOUT":      "3m",
+		"GCS_CLIENT_MAX_IDLE_CONNS":         "16",
+		"GCS_CLIENT_ENDPOINT":               "https://storage-gcshelper.p.googleapis.com",
+		"GCS_CLIENT_PATH_STYLE":             "true",
+		"GCS_CLIENT_CA_BUNDLE":              "/etc/ssl/private-ca.pem",
+		"GCS_CLIENT_ANONYMOUS":              "true",
 	})
 	config, err := LoadConfig()
 	if err != nil {
@@ -33,6 +74,20 @@ func TestLoadConfig(t *testing.T) {
 		BucketName: "some-bucket",
 		Listen:     "0.0.0.0:3030",
 		LogLevel:   "info",
+		Fallbacks: []FallbackBucket{
+			{Bucket: "old-bucket"},
+			{Bucket: "older-bucket", From: "videos/", To: "media/videos/"},
+		},
+		Backend: BackendConfig{
+			Buckets: map[string]string{"media": "gcs"},
+			S3: S3Config{
+				Endpoint:        "http://localhost:9000",
+				Region:          "sa-east-1",
+				AccessKeyID:     "access-key",
+				SecretAccessKey: "secret-key",
+				PathStyle:       true,
+			},
+		},
 		Proxy: ProxyConfig{
 			Endpoint:     "/proxy/",
 			LogHeaders:   []string{"Accept", "Range"},
@@ -40,13 +95,46 @@ func TestLoadConfig(t *testing.T) {
 			BucketOnPath: true,
 		},
 		Map: MapConfig{
-			Endpoint:    "/map/",
-			RegexFilter: `(240|360|424|480|720|1080)p\.(mp4|vtt|srt)$`,
+			Endpoint:        "/map/",
+			RegexFilter:     `(240|360|424|480|720|1080)p\.(mp4|vtt|srt)$`,
+			EmptyNotFound:   true,
+			MetadataFilters: []string{"status=ready", "language~^(es|en)$"},
+			FilterProfiles: FilterProfiles{
+				"mobile":        {Include: `(240|360)p\.mp4$`},
+				"captions-only": {Include: `\.vtt$`, Exclude: "_forced"},
+			},
+			ContentTypes:     []string{"video/*", "text/vtt"},
+			NamingTemplate:   "{id}_{*}_caption_{lang}_{*}.vtt",
+			Languages:        map[string]string{"wg": "en", "pb": "pt"},
+			SortBy:           "natural",
+			SortDescending:   true,
+			MediaFirst:       true,
+			Recursive:        true,
+			MaxDepth:         2,
+			Manifest:         "mapping.json",
+			ClipPath:         "{proxy}{bucket}/{object}?token={token}",
+			TokenSecret:      "token-secret",
+			TokenTTL:         30 * time.Minute,
+			PinGeneration:    true,
+			HLSVariantURL:    "/hls{path}/index.m3u8",
+			NegotiateFormat:  true,
+			CacheTTL:         5 * time.Minute,
+			CacheNegativeTTL: 10 * time.Second,
+			CacheSize:        2000,
+			AdminEndpoint:    "/admin/",
+			AdminToken:       "admin-token",
+			BatchConcurrency: 16,
+			Probe:            true,
+			ProbeCacheSize:   500,
 		},
 		Client: ClientConfig{
 			IdleConnTimeout: 3 * time.Minute,
 			MaxIdleConns:    16,
 			Timeout:         time.Minute,
+			Endpoint:        "https://storage-gcshelper.p.googleapis.com",
+			PathStyle:       true,
+			CABundle:        "/etc/ssl/private-ca.pem",
+			Anonymous:       true,
 		},
 	}
 	if !reflect.DeepEqual(config, expectedConfig) {
@@ -120,6 +208,197 @@ func TestLoadConfigValidation(t *testing.T) {
 	}
 }
 
+func TestLoadConfigValidationRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		envs  map[string]string
+		valid bool
+	}{
+		{
+			"local backend",
+			map[string]string{"GCS_HELPER_BACKEND": "local", "GCS_HELPER_LOCAL_ROOT": "/srv/media"},
+			true,
+		},
+		{
+			"local backend without root",
+			map[string]string{"GCS_HELPER_BACKEND": "local"},
+			false,
+		},
+		{
+			"s3 bucket",
+			map[string]string{"GCS_HELPER_BUCKET_BACKENDS": "media:s3,dev:local", "GCS_HELPER_LOCAL_ROOT": "/srv/media"},
+			true,
+		},
+		{
+			"metadata filters",
+			map[string]string{"GCS_HELPER_MAP_METADATA_FILTERS": "status=ready"},
+			true,
+		},
+		{
+			"metadata filters with the local backend",
+			map[string]string{"GCS_HELPER_BACKEND": "local", "GCS_HELPER_LOCAL_ROOT": "/srv/media", "GCS_HELPER_MAP_METADATA_FILTERS": "status=ready"},
+			false,
+		},
+		{
+			"metadata filters with an s3 bucket",
+			map[string]string{"GCS_HELPER_BUCKET_BACKENDS": "media:s3", "GCS_HELPER_MAP_METADATA_FILTERS": "status=ready"},
+			false,
+		},
+		{
+			"unknown bucket backend",
+			map[string]string{"GCS_HELPER_BUCKET_BACKENDS": "media:ftp"},
+			false,
+		},
+		{
+			"invalid fallback rewrite",
+			map[string]string{"GCS_HELPER_FALLBACK_BUCKETS": "old-bucket:videos/"},
+			false,
+		},
+		{
+			"unknown backend",
+			map[string]string{"GCS_HELPER_BACKEND": "ftp"},
+			false,
+		},
+		{
+			"naming pattern",
+			map[string]string{"GCS_HELPER_MAP_NAMING_PATTERN": `^(?P<id>\d+)_.*_(?P<lang>[a-z]{2,3})_`},
+			true,
+		},
+		{
+			"invalid naming pattern",
+			map[string]string{"GCS_HELPER_MAP_NAMING_PATTERN": `^(?P<id>\d+`},
+			false,
+		},
+		{
+			"naming pattern and template",
+			map[string]string{"GCS_HELPER_MAP_NAMING_PATTERN": `^(?P<id>\d+)_`, "GCS_HELPER_MAP_NAMING_TEMPLATE": "{id}_{*}"},
+			false,
+		},
+		{
+			"admin endpoint without token",
+			map[string]string{"GCS_HELPER_MAP_ADMIN_PREFIX": "/admin/"},
+			false,
+		},
+		{
+			"admin endpoint with token",
+			map[string]string{"GCS_HELPER_MAP_ADMIN_PREFIX": "/admin/", "GCS_HELPER_MAP_ADMIN_TOKEN": "admin-token"},
+			true,
+		},
+		{
+			"invalid regex filter",
+			map[string]string{"GCS_HELPER_MAP_REGEX_FILTER": `(\d+p\.mp4$`},
+			false,
+		},
+		{
+			"sort by group in the filter",
+			map[string]string{"GCS_HELPER_MAP_REGEX_FILTER": `(?P<height>\d+)p\.mp4$`, "GCS_HELPER_MAP_SORT_BY": "height"},
+			true,
+		},
+		{
+			"sort by unknown group",
+			map[string]string{"GCS_HELPER_MAP_NAMING_PATTERN": `(?P<height>\d+)p\.mp4$`, "GCS_HELPER_MAP_SORT_BY": "bitrate"},
+			false,
+		},
+		{
+			"metadata filters",
+			map[string]string{"GCS_HELPER_MAP_METADATA_FILTERS": "status=ready,reviewed"},
+			true,
+		},
+		{
+			"invalid metadata filter",
+			map[string]string{"GCS_HELPER_MAP_METADATA_FILTERS": "status~(ready"},
+			false,
+		},
+		{
+			"invalid filter profiles",
+			map[string]string{"GCS_HELPER_MAP_FILTER_PROFILES": `{"mobile": {"pattern": "360p"}}`},
+			false,
+		},
+		{
+			"invalid pattern in filter profile",
+			map[string]string{"GCS_HELPER_MAP_FILTER_PROFILES": `{"mobile": {"exclude": "(1080p"}}`},
+			false,
+		},
+		{
+			"invalid filter profile name",
+			map[string]string{"GCS_HELPER_MAP_FILTER_PROFILES": `{"mobile/hd": {"include": "720p"}}`},
+			false,
+		},
+		{
+			"clip path",
+			map[string]string{"GCS_HELPER_MAP_CLIP_PATH": "/mnt/{bucket}/{object}"},
+			true,
+		},
+		{
+			"pinned generations in local clip paths",
+			map[string]string{"GCS_HELPER_MAP_CLIP_PATH": "/mnt/{bucket}/{object}", "GCS_HELPER_MAP_PIN_GENERATION": "true"},
+			false,
+		},
+		{
+			"pinned generations in remote clip paths",
+			map[string]string{"GCS_HELPER_MAP_CLIP_PATH": "https://cdn.example.com/{bucket}/{object}", "GCS_HELPER_MAP_PIN_GENERATION": "true"},
+			true,
+		},
+		{
+			"clip path with unknown placeholder",
+			map[string]string{"GCS_HELPER_MAP_CLIP_PATH": "/mnt/{bucket}/{name}"},
+			false,
+		},
+		{
+			"clip path with token and no secret",
+			map[string]string{"GCS_HELPER_MAP_CLIP_PATH": "/{bucket}/{object}?token={token}"},
+			false,
+		},
+		{
+			"token secret without token parameter",
+			map[string]string{"GCS_HELPER_MAP_TOKEN_SECRET": "secret", "GCS_HELPER_MAP_CLIP_PATH": "https://cdn.example.com/{token}/{object}"},
+			false,
+		},
+		{
+			"token secret with default clip path",
+			map[string]string{"GCS_HELPER_MAP_TOKEN_SECRET": "secret"},
+			false,
+		},
+		{
+			"cache TTL shorter than token TTL",
+			map[string]string{"GCS_HELPER_MAP_TOKEN_SECRET": "secret", "GCS_HELPER_MAP_CLIP_PATH": "/{bucket}/{object}?token={token}", "GCS_HELPER_MAP_CACHE_TTL": "5m", "GCS_HELPER_MAP_TOKEN_TTL": "30m"},
+			true,
+		},
+		{
+			"cache TTL longer than token TTL",
+			map[string]string{"GCS_HELPER_MAP_TOKEN_SECRET": "secret", "GCS_HELPER_MAP_CLIP_PATH": "/{bucket}/{object}?token={token}", "GCS_HELPER_MAP_CACHE_TTL": "2h"},
+			false,
+		},
+		{
+			"HLS variant URL with unknown placeholder",
+			map[string]string{"GCS_HELPER_MAP_HLS_VARIANT_URL": "/hls/{object}/index.m3u8"},
+			false,
+		},
+		{
+			"unknown language",
+			map[string]string{"GCS_HELPER_MAP_NAMING_TEMPLATE": "{id}_{lang}.vtt", "GCS_HELPER_MAP_LANGUAGES": "wg:xx"},
+			false,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			envs := map[string]string{"GCS_HELPER_BUCKET_NAME": "some-bucket"}
+			for name, value := range test.envs {
+				envs[name] = value
+			}
+			setEnvs(envs)
+			_, err := LoadConfig()
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("unexpected <nil> error")
+			}
+		})
+	}
+}
+
 func TestHTTPClient(t *testing.T) {
 	os.Clearenv()
 	const timeout = 2 * time.Second
@@ -142,6 +421,41 @@ func TestHTTPClient(t *testing.T) {
 	}
 }
 
+func TestHTTPClientCABundle(t *testing.T) {
+	os.Clearenv()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	bundle, err := ioutil.TempFile("", "gcs-helper-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(bundle.Name())
+	pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	bundle.Close()
+
+	cfg := ClientConfig{Timeout: time.Second, CABundle: bundle.Name(), Anonymous: true}
+	hc, err := cfg.HTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := hc.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	cfg.CABundle = "testdata/google-creds.json"
+	_, err = cfg.HTTPClient()
+	if err == nil {
+		t.Error("unexpected <nil> error for invalid CA bundle")
+	}
+}
+
 func setEnvs(envs map[string]string) {
 	os.Clearenv()
 	for name, value := range envs {
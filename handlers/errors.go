@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/backend"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+	"google.golang.org/api/googleapi"
+)
+
+// errorResponse is the body of failed map requests.
+type errorResponse struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// writeError writes the error as JSON, with the given status code.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Status: status, Error: message})
+}
+
+// errorStatus returns the status code that best describes the error
+// returned while mapping a request:
+//
+//   - 400 for ranges that don't match the mapping
+//   - 403 when the storage denies access
+//   - 404 for missing buckets, objects and parts, and parts without
+//     renditions
+//   - 422 for invalid manifests, parts without a known duration and
+//     mappings that can't be written in the requested format
+//   - 502 for other failures reported by the storage
+//   - 504 when the storage times out
+//   - 500 for anything else
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, vodmodule.ErrInvalidRange):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrBucketNotExist),
+		errors.Is(err, storage.ErrObjectNotExist),
+		errors.Is(err, vodmodule.ErrEmptyPart),
+		errors.Is(err, vodmodule.ErrNoRenditions):
+		return http.StatusNotFound
+	case errors.Is(err, vodmodule.ErrUnsupportedMapping),
+		errors.Is(err, vodmodule.ErrMissingDuration),
+		errors.Is(err, vodmodule.ErrInvalidManifest):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return upstreamStatus(apiErr.Code)
+	}
+	var statusErr *backend.StatusError
+	if errors.As(err, &statusErr) {
+		return upstreamStatus(statusErr.StatusCode)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return http.StatusGatewayTimeout
+		}
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// upstreamStatus maps the status code returned by the storage service.
+func upstreamStatus(code int) int {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return http.StatusForbidden
+	case http.StatusNotFound:
+		return http.StatusNotFound
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/backend"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+	"google.golang.org/api/googleapi"
+)
+
+type timeoutError struct{ timeout bool }
+
+func (e timeoutError) Error() string   { return "i/o error" }
+func (e timeoutError) Timeout() bool   { return e.timeout }
+func (e timeoutError) Temporary() bool { return false }
+
+var _ net.Error = timeoutError{}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{storage.ErrBucketNotExist, http.StatusNotFound},
+		{fmt.Errorf("vodmodule: part %q: %w", "ads/", vodmodule.ErrEmptyPart), http.StatusNotFound},
+		{fmt.Errorf("vodmodule: %w: negative offset", vodmodule.ErrInvalidRange), http.StatusBadRequest},
+		{vodmodule.ErrNoRenditions, http.StatusNotFound},
+		{fmt.Errorf("%w: unknown bitrate", vodmodule.ErrUnsupportedMapping), http.StatusUnprocessableEntity},
+		{fmt.Errorf("vodmodule: clip %q: %w", "/my-bucket/ads/ad.mp4", vodmodule.ErrMissingDuration), http.StatusUnprocessableEntity},
+		{fmt.Errorf("%w %q: missing sequences", vodmodule.ErrInvalidManifest, "mapping.json"), http.StatusUnprocessableEntity},
+		{&googleapi.Error{Code: http.StatusForbidden}, http.StatusForbidden},
+		{&googleapi.Error{Code: http.StatusUnauthorized}, http.StatusForbidden},
+		{&googleapi.Error{Code: http.StatusNotFound}, http.StatusNotFound},
+		{&googleapi.Error{Code: http.StatusServiceUnavailable}, http.StatusBadGateway},
+		{&backend.StatusError{StatusCode: http.StatusForbidden}, http.StatusForbidden},
+		{&backend.StatusError{StatusCode: http.StatusInternalServerError}, http.StatusBadGateway},
+		{&backend.StatusError{StatusCode: http.StatusGatewayTimeout}, http.StatusGatewayTimeout},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{fmt.Errorf("listing: %w", timeoutError{timeout: true}), http.StatusGatewayTimeout},
+		{timeoutError{}, http.StatusBadGateway},
+		{errors.New("unexpected failure"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if got := errorStatus(test.err); got != test.expected {
+			t.Errorf("errorStatus(%v): want %d, got %d", test.err, test.expected, got)
+		}
+	}
+}
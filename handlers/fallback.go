@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// bucketHeader is the response header that reports the bucket that served the
+// request.
+const bucketHeader = "X-Gcs-Helper-Bucket"
+
+// FallbackBucket is a bucket that is tried when an object or a prefix can't be
+// found in the primary bucket.
+//
+// It's decoded from strings in the format "bucket" or "bucket:from=to". The
+// optional rewrite replaces the leading "from" in object names and prefixes
+// with "to" before looking them up in the fallback bucket.
+type FallbackBucket struct {
+	Bucket string
+	From   string
+	To     string
+}
+
+// Decode implements envconfig.Decoder.
+func (f *FallbackBucket) Decode(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	f.Bucket = parts[0]
+	if f.Bucket == "" {
+		return fmt.Errorf("invalid fallback bucket %q: missing bucket name", value)
+	}
+	if len(parts) > 1 {
+		rewrite := strings.SplitN(parts[1], "=", 2)
+		if len(rewrite) != 2 {
+			return fmt.Errorf("invalid fallback bucket %q: rewrite must be in the format from=to", value)
+		}
+		f.From, f.To = rewrite[0], rewrite[1]
+	}
+	return nil
+}
+
+func (f FallbackBucket) rewrite(name string) (string, bool) {
+	if !strings.HasPrefix(name, f.From) {
+		return "", false
+	}
+	return f.To + strings.TrimPrefix(name, f.From), true
+}
+
+// bucketTarget is a bucket and an object name (or prefix) within the bucket.
+type bucketTarget struct {
+	bucket   string
+	name     string
+	fallback FallbackBucket
+}
+
+// rewrite applies the rewrite of the fallback bucket (if any) to other names
+// looked up along with the target.
+func (t bucketTarget) rewrite(name string) (string, bool) {
+	return t.fallback.rewrite(name)
+}
+
+// rewriteAll rewrites the given names, dropping the ones that don't match the
+// rewrite.
+func (t bucketTarget) rewriteAll(names []string) []string {
+	var rewritten []string
+	for _, name := range names {
+		if r, ok := t.rewrite(name); ok {
+			rewritten = append(rewritten, r)
+		}
+	}
+	return rewritten
+}
+
+// bucketChain returns the list of targets that should be tried, in order, for
+// the given name. Fallback buckets only apply to the configured bucket.
+func (c Config) bucketChain(bucket, name string) []bucketTarget {
+	targets := []bucketTarget{{bucket: bucket, name: name}}
+	if bucket != c.BucketName {
+		return targets
+	}
+	for _, fallback := range c.Fallbacks {
+		if rewritten, ok := fallback.rewrite(name); ok {
+			targets = append(targets, bucketTarget{bucket: fallback.Bucket, name: rewritten, fallback: fallback})
+		}
+	}
+	return targets
+}
+
+// fallbackWriter is a ResponseWriter that discards "404 Not Found" responses,
+// so the request can be retried in the next bucket. Headers are only copied to
+// the underlying writer when the response is committed.
+type fallbackWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	notFound    bool
+}
+
+func newFallbackWriter(w http.ResponseWriter) *fallbackWriter {
+	return &fallbackWriter{w: w, header: http.Header{}}
+}
+
+func (f *fallbackWriter) Header() http.Header {
+	return f.header
+}
+
+func (f *fallbackWriter) WriteHeader(code int) {
+	if f.wroteHeader {
+		return
+	}
+	f.wroteHeader = true
+	if code == http.StatusNotFound {
+		f.notFound = true
+		return
+	}
+	for name, values := range f.header {
+		f.w.Header()[name] = values
+	}
+	f.w.WriteHeader(code)
+}
+
+func (f *fallbackWriter) Write(data []byte) (int, error) {
+	if !f.wroteHeader {
+		f.WriteHeader(http.StatusOK)
+	}
+	if f.notFound {
+		return len(data), nil
+	}
+	return f.w.Write(data)
+}
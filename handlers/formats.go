@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+)
+
+// Output formats of the map mode.
+const (
+	formatJSON = "json"
+	formatHLS  = "hls"
+	formatDASH = "dash"
+	formatSMIL = "smil"
+)
+
+// formatFiles maps the file names accepted as the last segment of the path
+// to output formats.
+var formatFiles = map[string]string{
+	"master.m3u8":   formatHLS,
+	"manifest.mpd":  formatDASH,
+	"playlist.smil": formatSMIL,
+}
+
+// formatMediaTypes maps the media types accepted in the Accept header to
+// output formats, when negotiation is enabled. Wildcards select the JSON
+// mapping.
+var formatMediaTypes = map[string]string{
+	"*/*":                           formatJSON,
+	"application/*":                 formatJSON,
+	"application/json":              formatJSON,
+	"application/vnd.apple.mpegurl": formatHLS,
+	"application/x-mpegurl":         formatHLS,
+	"audio/mpegurl":                 formatHLS,
+	"application/dash+xml":          formatDASH,
+	"application/smil+xml":          formatSMIL,
+	"application/smil":              formatSMIL,
+}
+
+// parseFormat returns the output format of the request, selected by the last
+// segment of the path (see formatFiles) or by the "format" query string
+// parameter, along with the path without the file name. Without either of
+// them, the format is negotiated with the Accept header when negotiate is
+// set, and defaults to the JSON mapping.
+//
+// nginx-vod-module may forward the Accept header of players in its mapping
+// requests, so negotiation is opt-in.
+func parseFormat(path string, query url.Values, header http.Header, negotiate bool) (string, string, error) {
+	var format string
+	if i := strings.LastIndex(path, "/"); formatFiles[path[i+1:]] != "" {
+		format, path = formatFiles[path[i+1:]], path[:i+1]
+	}
+	if value := query.Get("format"); value != "" {
+		switch value {
+		case formatJSON, formatHLS, formatDASH, formatSMIL:
+		default:
+			return "", path, errors.New("invalid format")
+		}
+		if format != "" && format != value {
+			return "", path, errors.New("conflicting formats in the path and in the query string")
+		}
+		format = value
+	}
+	if format == "" {
+		format = formatJSON
+		if negotiate {
+			format = negotiateFormat(header.Get("Accept"))
+		}
+	}
+	return format, path, nil
+}
+
+// negotiateFormat returns the output format with the highest quality in the
+// given Accept header, preferring the first one listed on ties. Media types
+// that aren't supported are ignored, and the format defaults to the JSON
+// mapping.
+func negotiateFormat(accept string) string {
+	format, quality := formatJSON, 0.0
+	for _, value := range strings.Split(accept, ",") {
+		params := strings.Split(value, ";")
+		f := formatMediaTypes[strings.ToLower(strings.TrimSpace(params[0]))]
+		if f == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > quality {
+			format, quality = f, q
+		}
+	}
+	return format
+}
+
+// hlsOptions returns the options of HLS master playlists.
+func (c MapConfig) hlsOptions() vodmodule.HLSOptions {
+	return vodmodule.HLSOptions{VariantURL: c.HLSVariantURL}
+}
+
+// render encodes the mapping in the requested format, returning the content
+// type and the body.
+func (h *MapHandler) render(format string, m *vodmodule.Mapping) (string, []byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case formatHLS:
+		if err := m.WriteHLS(&buf, h.config.Map.hlsOptions()); err != nil {
+			return "", nil, err
+		}
+		return "application/vnd.apple.mpegurl", buf.Bytes(), nil
+	case formatDASH:
+		if err := m.WriteDASH(&buf); err != nil {
+			return "", nil, err
+		}
+		return "application/dash+xml", buf.Bytes(), nil
+	case formatSMIL:
+		if err := m.WriteSMIL(&buf); err != nil {
+			return "", nil, err
+		}
+		return "application/smil+xml", buf.Bytes(), nil
+	default:
+		if err := json.NewEncoder(&buf).Encode(m); err != nil {
+			return "", nil, err
+		}
+		return "application/json", buf.Bytes(), nil
+	}
+}
@@ -1,41 +1,271 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 
 	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/backend"
 	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+	"github.com/sirupsen/logrus"
 )
 
+// MapHandler is the handler of the map mode, which lists objects and returns
+// mappings in the format expected by nginx-vod-module.
+type MapHandler struct {
+	config          Config
+	backends        backend.Backend
+	filter          *regexp.Regexp
+	naming          *vodmodule.NamingConvention
+	metadataFilters []vodmodule.MetadataFilter
+	profiles        map[string]filterProfile
+	clipPath        *vodmodule.ClipPathTemplate
+	prober          *vodmodule.Prober
+	cache           *vodmodule.MappingCache
+	logger          *logrus.Logger
+}
+
 // Map returns the map handler.
 func Map(c Config, client *storage.Client) http.Handler {
-	mapper := vodmodule.NewMapper(client.Bucket(c.BucketName))
-	filter := regexp.MustCompile(c.Map.RegexFilter)
-	logger := c.Logger()
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer r.Body.Close()
-		if r.Method != http.MethodGet {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		prefix := strings.TrimLeft(r.URL.Path, "/")
-		if prefix == "" {
-			http.Error(w, "prefix cannot be empty", http.StatusBadRequest)
-			return
-		}
-		m, err := mapper.Map(r.Context(), vodmodule.MapOptions{
-			Prefix: prefix,
-			Filter: filter,
+	return NewMapHandler(c, client)
+}
+
+// NewMapHandler returns the map handler. It panics if the configuration is
+// invalid (see LoadConfig).
+func NewMapHandler(c Config, client *storage.Client) *MapHandler {
+	h := MapHandler{
+		config:   c,
+		backends: c.newBackend(client, nil),
+		filter:   regexp.MustCompile(c.Map.RegexFilter),
+		logger:   c.Logger(),
+	}
+	var err error
+	if h.naming, err = c.Map.namingConvention(); err != nil {
+		panic(err)
+	}
+	if h.metadataFilters, err = c.Map.metadataFilters(); err != nil {
+		panic(err)
+	}
+	if h.profiles, err = c.Map.FilterProfiles.compile(); err != nil {
+		panic(err)
+	}
+	if h.clipPath, err = c.clipPath(); err != nil {
+		panic(err)
+	}
+	if c.Map.Probe {
+		h.prober = vodmodule.NewProber(c.Map.ProbeCacheSize)
+	}
+	if c.Map.CacheTTL > 0 {
+		h.cache = vodmodule.NewMappingCache(c.Map.CacheSize, c.Map.CacheTTL, c.Map.CacheNegativeTTL)
+	}
+	return &h
+}
+
+// Invalidate removes the cached mappings that include objects under the
+// given prefix of the bucket, returning the number of removed mappings. It
+// should be called by ingest jobs after changing a prefix.
+func (h *MapHandler) Invalidate(bucket, prefix string) int {
+	if h.cache == nil {
+		return 0
+	}
+	return h.cache.Invalidate(bucket, prefix)
+}
+
+func (h *MapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.config.Map.NegotiateFormat {
+		w.Header().Set("Vary", "Accept")
+	}
+	req, err := h.parseRequest(r.URL.Path, r.URL.Query(), r.Header)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	m, bucket, status, err := h.resolve(r.Context(), req)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	h.logger.WithFields(logrus.Fields{"prefix": req.prefix, "bucket": bucket, "format": req.format}).Debug("mapped request")
+	contentType, body, err := h.render(req.format, &m)
+	if err != nil {
+		status := errorStatus(err)
+		h.logger.WithError(err).WithFields(logrus.Fields{"prefix": req.prefix, "bucket": bucket, "format": req.format, "status": status}).Debug("failed to render mapping")
+		writeError(w, status, err.Error())
+		return
+	}
+	w.Header().Set(bucketHeader, bucket)
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+// resolve maps the request and applies its ranges, returning the mapping and
+// the bucket that served it. On failures, it returns the status code that
+// describes the error.
+func (h *MapHandler) resolve(ctx context.Context, req mapRequest) (vodmodule.Mapping, string, int, error) {
+	m, bucket, err := h.mapping(ctx, req)
+	if err != nil {
+		status := errorStatus(err)
+		entry := h.logger.WithError(err).WithFields(logrus.Fields{"prefix": req.prefix, "bucket": bucket, "status": status})
+		if status >= http.StatusInternalServerError {
+			entry.Error("failed to map request")
+		} else {
+			entry.Debug("failed to map request")
+		}
+		return m, bucket, status, err
+	}
+	if len(m.Sequences) == 0 && h.config.Map.EmptyNotFound {
+		return m, bucket, http.StatusNotFound, errors.New("no objects found")
+	}
+	if err := req.trim.apply(&m); err != nil {
+		return m, bucket, http.StatusBadRequest, err
+	}
+	return m, bucket, http.StatusOK, nil
+}
+
+// mapRequest is a parsed map request.
+type mapRequest struct {
+	prefix   string
+	prefixes []string
+	parts    []string
+	mode     string
+	profile  *filterProfile
+	trim     trimRequest
+	format   string
+}
+
+// parseRequest parses the path, the query string and the headers of a map
+// request.
+func (h *MapHandler) parseRequest(path string, query url.Values, header http.Header) (mapRequest, error) {
+	var (
+		req mapRequest
+		err error
+	)
+	req.format, path, err = parseFormat(path, query, header, h.config.Map.NegotiateFormat)
+	if err != nil {
+		return req, err
+	}
+	req.prefix, req.profile, err = selectProfile(h.profiles, strings.TrimLeft(path, "/"), query)
+	if err != nil {
+		return req, err
+	}
+	req.prefix, req.trim, err = parseTrimRequest(req.prefix, query)
+	if err != nil {
+		return req, err
+	}
+	if req.format != formatJSON && !req.trim.isZero() {
+		return req, errors.New("ranges are only supported in JSON mappings")
+	}
+	if req.parts, err = cleanPrefixes(query["part"]); err != nil {
+		return req, err
+	}
+	req.mode = query.Get("mode")
+	if len(req.parts) > 0 {
+		if req.prefix != "" {
+			return req, errors.New("prefix and parts are mutually exclusive")
+		}
+		if req.mode != "" && req.mode != vodmodule.PartModeClips && req.mode != vodmodule.PartModeConcat {
+			return req, errors.New("invalid mode")
+		}
+		req.prefix = req.parts[0]
+	}
+	if req.prefix == "" {
+		return req, errors.New("prefix cannot be empty")
+	}
+	req.prefixes, err = cleanPrefixes(query["prefix"])
+	return req, err
+}
+
+// mapping maps the request, trying fallback buckets when the prefix can't be
+// found. It returns the bucket that served the mapping.
+func (h *MapHandler) mapping(ctx context.Context, req mapRequest) (vodmodule.Mapping, string, error) {
+	var (
+		m      vodmodule.Mapping
+		err    error
+		bucket string
+	)
+	for i, target := range h.config.bucketChain(h.config.BucketName, req.prefix) {
+		if i > 0 {
+			h.logger.WithFields(logrus.Fields{"prefix": req.prefix, "bucket": target.bucket}).Debug("trying fallback bucket")
+		}
+		opts := h.options(target, req)
+		key := vodmodule.MappingCacheKey{Bucket: target.bucket, Prefixes: append([]string{opts.Prefix}, opts.Prefixes...)}
+		if req.profile != nil {
+			key.Variant = req.profile.name
+		}
+		if len(req.parts) > 0 {
+			parts := target.rewriteAll(req.parts)
+			if len(parts) != len(req.parts) {
+				continue
+			}
+			key.Prefixes = parts
+			key.Variant += "\x00parts\x00" + req.mode
+		}
+		bucket = target.bucket
+		m, err = h.cached(key, func() (vodmodule.Mapping, error) {
+			mapper := vodmodule.NewBucketMapper(h.backends.Bucket(target.bucket))
+			if len(req.parts) > 0 {
+				return mapper.MapParts(ctx, key.Prefixes, req.mode, opts)
+			}
+			return mapper.Map(ctx, opts)
 		})
-		if err != nil {
-			logger.WithError(err).WithField("prefix", prefix).Error("failed to map request")
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(m)
-	})
+		if (err == nil && len(m.Sequences) > 0) || (err != nil && !errors.Is(err, storage.ErrBucketNotExist) && !errors.Is(err, vodmodule.ErrEmptyPart)) {
+			break
+		}
+	}
+	return m, bucket, err
+}
+
+// options returns the options used to map the request in the given target.
+func (h *MapHandler) options(target bucketTarget, req mapRequest) vodmodule.MapOptions {
+	opts := vodmodule.MapOptions{
+		Prefix:          target.name,
+		Prefixes:        target.rewriteAll(req.prefixes),
+		Recursive:       h.config.Map.Recursive,
+		MaxDepth:        h.config.Map.MaxDepth,
+		Filter:          h.filter,
+		MetadataFilters: h.metadataFilters,
+		ContentTypes:    h.config.Map.ContentTypes,
+		Naming:          h.naming,
+		Order:           h.config.Map.ordering(),
+		Manifest:        h.config.Map.Manifest,
+		ClipPath:        h.clipPath,
+		Prober:          h.prober,
+	}
+	if req.profile != nil {
+		if req.profile.include != nil {
+			opts.Filter = req.profile.include
+		}
+		opts.Exclude = req.profile.exclude
+	}
+	return opts
+}
+
+func (h *MapHandler) cached(key vodmodule.MappingCacheKey, fn func() (vodmodule.Mapping, error)) (vodmodule.Mapping, error) {
+	if h.cache == nil {
+		return fn()
+	}
+	return h.cache.Get(key, fn)
+}
+
+// cleanPrefixes removes the leading slash from the given prefixes, which can't
+// be empty.
+func cleanPrefixes(values []string) ([]string, error) {
+	var prefixes []string
+	for _, p := range values {
+		p = strings.TrimLeft(p, "/")
+		if p == "" {
+			return nil, errors.New("prefix cannot be empty")
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
 }
@@ -1,12 +1,22 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/NYTimes/gcs-helper/v3/internal/testhelper"
 	"github.com/NYTimes/gcs-helper/v3/vodmodule"
 	"github.com/google/go-cmp/cmp"
 )
@@ -66,6 +76,735 @@ func TestServerMapListOfFiles(t *testing.T) {
 	}
 }
 
+func TestServerMapNamingTemplate(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Map: MapConfig{
+			RegexFilter:    `caption.*\.vtt$`,
+			NamingTemplate: "{id}_{*}_caption_{lang}_{*}.vtt",
+			Languages:      map[string]string{"wg": "en"},
+		},
+		Proxy: ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	resp, err := http.Get(addr + "/videos/video/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	expectedBody := vodmodule.Mapping{
+		Sequences: []vodmodule.Sequence{
+			{
+				ID:       "77071",
+				Language: "eng",
+				Label:    "English",
+				Clips: []vodmodule.Clip{
+					{
+						Type: "source",
+						Path: "/my-bucket/videos/video/77071_1_caption_wg_240p_001f8ea7-749b-4d43-7bd5-b357e4e24f32.vtt",
+					},
+				},
+			},
+		},
+	}
+	var body vodmodule.Mapping
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(body, expectedBody) {
+		t.Errorf("wrong body returned\nwant %#v\ngot  %#v\ndiff: %s", expectedBody, body, cmp.Diff(body, expectedBody))
+	}
+}
+
+func TestServerMapNamedGroupsInFilter(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Map:        MapConfig{RegexFilter: `_(?P<id>\d+p)_(?P<bitrate>\d+)k\.mp4$`},
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	resp, err := http.Get(addr + "/videos/renditions/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	expectedBody := vodmodule.Mapping{
+		Sequences: []vodmodule.Sequence{
+			{
+				ID:      "1080p",
+				Bitrate: &vodmodule.Bitrate{Video: 4500000},
+				Clips:   []vodmodule.Clip{{Type: "source", Path: "/my-bucket/videos/renditions/show_1080p_4500k.mp4"}},
+			},
+			{
+				ID:      "720p",
+				Bitrate: &vodmodule.Bitrate{Video: 2500000},
+				Clips:   []vodmodule.Clip{{Type: "source", Path: "/my-bucket/videos/renditions/show_720p_2500k.mp4"}},
+			},
+		},
+	}
+	var body vodmodule.Mapping
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(body, expectedBody) {
+		t.Errorf("wrong body returned\nwant %#v\ngot  %#v\ndiff: %s", expectedBody, body, cmp.Diff(body, expectedBody))
+	}
+}
+
+func TestServerMapSortBy(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Map: MapConfig{
+			RegexFilter:    `(?P<height>\d+)p\.mp4$`,
+			SortBy:         "height",
+			SortDescending: true,
+		},
+		Proxy: ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	resp, err := http.Get(addr + "/videos/video/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body vodmodule.Mapping
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPaths := []string{
+		"/my-bucket/videos/video/28043_1_video_1080p.mp4",
+		"/my-bucket/videos/video/video1_720p.mp4",
+		"/my-bucket/videos/video/video1_480p.mp4",
+	}
+	var paths []string
+	for _, seq := range body.Sequences {
+		for _, clip := range seq.Clips {
+			paths = append(paths, clip.Path)
+		}
+	}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("wrong order of sequences\nwant %q\ngot  %q", expectedPaths, paths)
+	}
+}
+
+func TestServerMapMetadataFilters(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Map: MapConfig{
+			MetadataFilters: []string{"status=ready"},
+			ContentTypes:    []string{"video/*"},
+		},
+		Proxy: ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	resp, err := http.Get(addr + "/videos/published/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body vodmodule.Mapping
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPaths := []string{"/my-bucket/videos/published/video_480p.mp4"}
+	var paths []string
+	for _, seq := range body.Sequences {
+		for _, clip := range seq.Clips {
+			paths = append(paths, clip.Path)
+		}
+	}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("wrong sequences\nwant %q\ngot  %q", expectedPaths, paths)
+	}
+}
+
+func TestServerMapFilterProfiles(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Map: MapConfig{
+			RegexFilter: `\.mp4$`,
+			FilterProfiles: FilterProfiles{
+				"mobile":   {Exclude: "1080p"},
+				"captions": {Include: `\.vtt$`},
+			},
+		},
+		Proxy: ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	tests := []struct {
+		path          string
+		expectedPaths []string
+	}{
+		{
+			"/videos/published/",
+			[]string{
+				"/my-bucket/videos/published/video_1080p.mp4",
+				"/my-bucket/videos/published/video_480p.mp4",
+				"/my-bucket/videos/published/video_720p.mp4",
+			},
+		},
+		{
+			"/mobile/videos/published/",
+			[]string{
+				"/my-bucket/videos/published/video_480p.mp4",
+				"/my-bucket/videos/published/video_720p.mp4",
+			},
+		},
+		{
+			"/videos/published/?profile=captions",
+			[]string{"/my-bucket/videos/published/captions_es.vtt"},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.path, func(t *testing.T) {
+			resp, err := http.Get(addr + test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var body vodmodule.Mapping
+			err = json.NewDecoder(resp.Body).Decode(&body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var paths []string
+			for _, seq := range body.Sequences {
+				for _, clip := range seq.Clips {
+					paths = append(paths, clip.Path)
+				}
+			}
+			if !reflect.DeepEqual(paths, test.expectedPaths) {
+				t.Errorf("wrong sequences\nwant %q\ngot  %q", test.expectedPaths, paths)
+			}
+		})
+	}
+}
+
+func TestServerMapUnknownFilterProfile(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Map:        MapConfig{FilterProfiles: FilterProfiles{"mobile": {Exclude: "1080p"}}},
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	for _, path := range []string{
+		"/videos/published/?profile=tv",
+		"/mobile/videos/published/?profile=tv",
+	} {
+		resp, err := http.Get(addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: wrong status code\nwant %d\ngot  %d", path, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
+
+func TestServerMapClipPath(t *testing.T) {
+	t.Parallel()
+	c := Config{
+		BucketName: "my-bucket",
+		Map: MapConfig{
+			RegexFilter: `_480p\.mp4$`,
+			ClipPath:    "{proxy}{bucket}/{object}?token={token}",
+			TokenSecret: "token-secret",
+		},
+		Proxy: ProxyConfig{Endpoint: "/proxy/", Timeout: time.Second},
+	}
+	addr, cleanup := testMapServer(t, c)
+	defer cleanup()
+	resp, err := http.Get(addr + "/videos/published/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body vodmodule.Mapping
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Sequences) != 1 {
+		t.Fatalf("wrong number of sequences\nwant 1\ngot  %d", len(body.Sequences))
+	}
+	const prefix = "/proxy/my-bucket/videos/published/video_480p.mp4?token="
+	path := body.Sequences[0].Clips[0].Path
+	if !strings.HasPrefix(path, prefix) {
+		t.Fatalf("wrong clip path\nwant %s...\ngot  %s", prefix, path)
+	}
+	signer := vodmodule.TokenSigner{Secret: []byte(c.Map.TokenSecret)}
+	if err := signer.Verify("my-bucket", "videos/published/video_480p.mp4", strings.TrimPrefix(path, prefix)); err != nil {
+		t.Errorf("invalid token in %s: %v", path, err)
+	}
+}
+
+func TestServerMapPinGeneration(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	c := Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map:        MapConfig{RegexFilter: `_480p\.mp4$`, PinGeneration: true},
+		Proxy:      ProxyConfig{BucketOnPath: true, Timeout: time.Second},
+	}
+	mapServer := httptest.NewServer(Map(c, nil))
+	defer mapServer.Close()
+	proxyServer := httptest.NewServer(Proxy(c, nil))
+	defer proxyServer.Close()
+
+	resp, err := http.Get(mapServer.URL + "/videos/published/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body vodmodule.Mapping
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Sequences) != 1 {
+		t.Fatalf("wrong number of sequences\nwant 1\ngot  %d", len(body.Sequences))
+	}
+	const prefix = "/my-bucket/videos/published/video_480p.mp4?generation="
+	path := body.Sequences[0].Clips[0].Path
+	if !strings.HasPrefix(path, prefix) {
+		t.Fatalf("wrong clip path\nwant %s...\ngot  %s", prefix, path)
+	}
+
+	fetch := func() int {
+		resp, err := http.Get(proxyServer.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := fetch(); code != http.StatusOK {
+		t.Errorf("wrong status code for the pinned generation\nwant %d\ngot  %d", http.StatusOK, code)
+	}
+	replaced := time.Now().Add(time.Hour)
+	err = os.Chtimes(filepath.Join(root, "my-bucket", "videos", "published", "video_480p.mp4"), replaced, replaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := fetch(); code != http.StatusNotFound {
+		t.Errorf("wrong status code for a replaced object\nwant %d\ngot  %d", http.StatusNotFound, code)
+	}
+}
+
+func TestServerMapHLS(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	server := httptest.NewServer(Map(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map:        MapConfig{RegexFilter: `^clip1_`, Probe: true},
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	}, nil))
+	defer server.Close()
+	for _, path := range []string{"/videos/probed/master.m3u8", "/videos/probed/?format=hls"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: wrong status code\nwant %d\ngot  %d: %s", path, http.StatusOK, resp.StatusCode, body)
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/vnd.apple.mpegurl" {
+			t.Errorf("%s: wrong content type %q", path, contentType)
+		}
+		expected := "#EXTM3U\n" +
+			"#EXT-X-VERSION:3\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=1128000,AVERAGE-BANDWIDTH=1128000,CODECS=\"avc1.64001f,mp4a.40.2\",RESOLUTION=854x480\n" +
+			"/my-bucket/videos/probed/clip1_480p.mp4/index.m3u8\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=2628000,AVERAGE-BANDWIDTH=2628000,CODECS=\"avc1.64001f,mp4a.40.2\",RESOLUTION=1280x720\n" +
+			"/my-bucket/videos/probed/clip1_720p.mp4/index.m3u8\n"
+		if string(body) != expected {
+			t.Errorf("%s: wrong playlist\nwant:\n%s\ngot:\n%s", path, expected, body)
+		}
+	}
+}
+
+func TestServerMapDASH(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	dir := filepath.Join(root, "my-bucket", "videos", "dash")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	video := testhelper.FakeMP4(testhelper.MP4Options{Duration: 6000, Width: 1280, Height: 720, VideoBitrate: 2000000, SegmentIndex: true})
+	audio := testhelper.FakeMP4(testhelper.MP4Options{Duration: 6000, AudioBitrate: 128000, Language: "eng", SegmentIndex: true})
+	for name, data := range map[string][]byte{"video_720p.mp4": video, "audio_en.mp4": audio} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewServer(Map(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map:        MapConfig{Probe: true},
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	}, nil))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/videos/dash/manifest.mpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code\nwant %d\ngot  %d: %s", http.StatusOK, resp.StatusCode, body)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/dash+xml" {
+		t.Errorf("wrong content type %q", contentType)
+	}
+	var mpd struct {
+		Duration       string `xml:"mediaPresentationDuration,attr"`
+		AdaptationSets []struct {
+			ContentType     string `xml:"contentType,attr"`
+			Lang            string `xml:"lang,attr"`
+			Representations []struct {
+				BaseURL     string `xml:"BaseURL"`
+				SegmentBase struct {
+					IndexRange string `xml:"indexRange,attr"`
+				} `xml:"SegmentBase"`
+			} `xml:"Representation"`
+		} `xml:"Period>AdaptationSet"`
+	}
+	if err := xml.Unmarshal(body, &mpd); err != nil {
+		t.Fatal(err)
+	}
+	if mpd.Duration != "PT6S" {
+		t.Errorf("wrong duration\nwant PT6S\ngot  %s", mpd.Duration)
+	}
+	// FakeMP4 writes the 40-byte sidx box right before the 1032-byte mdat box.
+	expected := []struct{ contentType, lang, baseURL, indexRange string }{
+		{"audio", "en", "/my-bucket/videos/dash/audio_en.mp4", fmt.Sprintf("%d-%d", len(audio)-1072, len(audio)-1033)},
+		{"video", "", "/my-bucket/videos/dash/video_720p.mp4", fmt.Sprintf("%d-%d", len(video)-1072, len(video)-1033)},
+	}
+	if len(mpd.AdaptationSets) != len(expected) {
+		t.Fatalf("wrong number of adaptation sets\nwant %d\ngot  %d:\n%s", len(expected), len(mpd.AdaptationSets), body)
+	}
+	for i, set := range mpd.AdaptationSets {
+		if set.ContentType != expected[i].contentType || set.Lang != expected[i].lang || len(set.Representations) != 1 {
+			t.Errorf("wrong adaptation set %d:\n%s", i, body)
+			continue
+		}
+		rep := set.Representations[0]
+		if rep.BaseURL != expected[i].baseURL || rep.SegmentBase.IndexRange != expected[i].indexRange {
+			t.Errorf("wrong representation\nwant %s (%s)\ngot  %s (%s)", expected[i].baseURL, expected[i].indexRange, rep.BaseURL, rep.SegmentBase.IndexRange)
+		}
+	}
+}
+
+type smilVideo struct {
+	Src     string `xml:"src,attr"`
+	Bitrate int64  `xml:"system-bitrate,attr"`
+	Height  int    `xml:"height,attr"`
+}
+
+func TestServerMapSMIL(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	server := httptest.NewServer(Map(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map:        MapConfig{RegexFilter: `^clip1_`, Probe: true, NegotiateFormat: true},
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	}, nil))
+	defer server.Close()
+	tests := []struct {
+		path   string
+		accept string
+	}{
+		{"/videos/probed/playlist.smil", ""},
+		{"/videos/probed/?format=smil", "application/json"},
+		{"/videos/probed/", "application/smil+xml"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+test.path, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: wrong status code\nwant %d\ngot  %d: %s", test.path, http.StatusOK, resp.StatusCode, body)
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/smil+xml" {
+			t.Errorf("%s: wrong content type %q", test.path, contentType)
+		}
+		var smil struct {
+			Videos []smilVideo `xml:"body>switch>video"`
+		}
+		if err := xml.Unmarshal(body, &smil); err != nil {
+			t.Fatal(err)
+		}
+		expected := []smilVideo{
+			{"/my-bucket/videos/probed/clip1_480p.mp4", 1128000, 480},
+			{"/my-bucket/videos/probed/clip1_720p.mp4", 2628000, 720},
+		}
+		if !reflect.DeepEqual(smil.Videos, expected) {
+			t.Errorf("%s: wrong renditions\nwant %#v\ngot  %#v", test.path, expected, smil.Videos)
+		}
+	}
+}
+
+func TestServerMapNegotiateFormat(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	server := httptest.NewServer(Map(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map:        MapConfig{RegexFilter: `^clip1_`, Probe: true, NegotiateFormat: true},
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	}, nil))
+	defer server.Close()
+	tests := []struct {
+		path                string
+		accept              string
+		expectedContentType string
+	}{
+		{"/videos/probed/", "", "application/json"},
+		{"/videos/probed/", "text/html,application/xhtml+xml,*/*;q=0.8", "application/json"},
+		{"/videos/probed/", "text/html", "application/json"},
+		{"/videos/probed/", "application/x-mpegURL", "application/vnd.apple.mpegurl"},
+		{"/videos/probed/", "application/dash+xml;q=0.5, application/vnd.apple.mpegurl", "application/vnd.apple.mpegurl"},
+		{"/videos/probed/", "application/dash+xml;q=0.5, application/smil+xml", "application/smil+xml"},
+		{"/videos/probed/", "application/json;q=0.9, application/dash+xml;q=0.9", "application/json"},
+		{"/videos/probed/", "application/smil+xml;q=0, */*", "application/json"},
+		{"/videos/probed/?format=json", "application/smil+xml", "application/json"},
+		{"/videos/probed/master.m3u8", "application/dash+xml", "application/vnd.apple.mpegurl"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+test.path, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s (%s): wrong status code\nwant %d\ngot  %d", test.path, test.accept, http.StatusOK, resp.StatusCode)
+			continue
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != test.expectedContentType {
+			t.Errorf("%s (%s): wrong content type\nwant %q\ngot  %q", test.path, test.accept, test.expectedContentType, contentType)
+		}
+		if vary := resp.Header.Get("Vary"); vary != "Accept" {
+			t.Errorf("%s (%s): wrong Vary header %q", test.path, test.accept, vary)
+		}
+	}
+}
+
+func TestServerMapAcceptIgnoredByDefault(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	server := httptest.NewServer(Map(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map:        MapConfig{RegexFilter: `^clip1_`, Probe: true},
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	}, nil))
+	defer server.Close()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/videos/probed/", nil)
+	req.Header.Set("Accept", "application/vnd.apple.mpegurl")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("wrong content type\nwant %q\ngot  %q", "application/json", contentType)
+	}
+	if vary := resp.Header.Get("Vary"); vary != "" {
+		t.Errorf("unexpected Vary header %q", vary)
+	}
+}
+
+func TestServerMapFormatInvalidRequests(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Map:        MapConfig{RegexFilter: `\.mp4$`},
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	tests := []struct {
+		path           string
+		expectedStatus int
+	}{
+		{"/videos/published/master.m3u8", http.StatusUnprocessableEntity},
+		{"/videos/published/manifest.mpd", http.StatusUnprocessableEntity},
+		{"/videos/published/playlist.smil?format=dash", http.StatusBadRequest},
+		{"/videos/published/master.m3u8?format=json", http.StatusBadRequest},
+		{"/videos/published/?format=xml", http.StatusBadRequest},
+		{"/videos/published/clipFrom/1000/master.m3u8", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(addr + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.expectedStatus {
+			t.Errorf("%s: wrong status code\nwant %d\ngot  %d", test.path, test.expectedStatus, resp.StatusCode)
+		}
+	}
+}
+
+func TestServerMapMultiplePrefixes(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Map: MapConfig{
+			RegexFilter: `^(video1_480p\.mp4|video1\.srt|4\.mp3)$`,
+			Recursive:   true,
+			MediaFirst:  true,
+		},
+		Proxy: ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	resp, err := http.Get(addr + "/videos/?prefix=subs/&prefix=/musics/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	var body vodmodule.Mapping
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPaths := []string{
+		"/my-bucket/musics/music/music/4.mp3",
+		"/my-bucket/videos/video/video1_480p.mp4",
+		"/my-bucket/subs/video1.srt",
+	}
+	var paths []string
+	for _, seq := range body.Sequences {
+		for _, clip := range seq.Clips {
+			paths = append(paths, clip.Path)
+		}
+	}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("wrong sequences\nwant %q\ngot  %q", expectedPaths, paths)
+	}
+}
+
+func TestServerMapEmptyAdditionalPrefix(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	resp, err := http.Get(addr + "/videos/video/?prefix=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestServerMapManifest(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Map:        MapConfig{Manifest: "mapping.json"},
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	resp, err := http.Get(addr + "/videos/trimmed/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	expectedBody := vodmodule.Mapping{
+		Sequences: []vodmodule.Sequence{
+			{
+				Clips: []vodmodule.Clip{
+					{
+						Type:     "source",
+						Path:     "/my-bucket/videos/trimmed/video_480p.mp4",
+						ClipFrom: 5000,
+						ClipTo:   20000,
+					},
+				},
+			},
+		},
+	}
+	var body vodmodule.Mapping
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(body, expectedBody) {
+		t.Errorf("wrong body returned\nwant %#v\ngot  %#v\ndiff: %s", expectedBody, body, cmp.Diff(body, expectedBody))
+	}
+}
+
+func TestServerMapInvalidManifest(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Map:        MapConfig{Manifest: "mapping.json"},
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	resp, err := http.Get(addr + "/videos/broken/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusUnprocessableEntity, resp.StatusCode)
+	}
+}
+
 func TestServerMapInvalidMethod(t *testing.T) {
 	t.Parallel()
 	addr, cleanup := testMapServer(t, Config{
@@ -123,7 +862,475 @@ func TestServerMapBucketNotFound(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusInternalServerError {
-		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusInternalServerError, resp.StatusCode)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusNotFound, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("wrong content type\nwant %q\ngot  %q", "application/json", contentType)
+	}
+	var body errorResponse
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != http.StatusNotFound || body.Error == "" {
+		t.Errorf("wrong error body: %#v", body)
+	}
+}
+
+func TestServerMapEmpty(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		emptyNotFound  bool
+		expectedStatus int
+	}{
+		{"empty mapping", false, http.StatusOK},
+		{"not found", true, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			addr, cleanup := testMapServer(t, Config{
+				BucketName: "my-bucket",
+				Map:        MapConfig{EmptyNotFound: test.emptyNotFound},
+				Proxy:      ProxyConfig{Timeout: time.Second},
+			})
+			defer cleanup()
+			resp, err := http.Get(addr + "/videos/missing/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != test.expectedStatus {
+				t.Errorf("wrong status code\nwant %d\ngot  %d", test.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestServerMapLocalBackend(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	httpServer := httptest.NewServer(Map(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map:        MapConfig{RegexFilter: `\.mp4$`},
+	}, nil))
+	defer httpServer.Close()
+	resp, err := http.Get(httpServer.URL + "/videos/video/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	expectedBody := vodmodule.Mapping{
+		Sequences: []vodmodule.Sequence{
+			{Clips: []vodmodule.Clip{{Type: "source", Path: "/my-bucket/videos/video/28043_1_video_1080p.mp4"}}},
+			{Clips: []vodmodule.Clip{{Type: "source", Path: "/my-bucket/videos/video/video1_480p.mp4"}}},
+			{Clips: []vodmodule.Clip{{Type: "source", Path: "/my-bucket/videos/video/video1_720p.mp4"}}},
+		},
+	}
+	var body vodmodule.Mapping
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(body, expectedBody) {
+		t.Errorf("wrong body returned\nwant %#v\ngot  %#v\ndiff: %s", expectedBody, body, cmp.Diff(body, expectedBody))
+	}
+}
+
+func TestServerMapProbe(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	httpServer := httptest.NewServer(Map(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map: MapConfig{
+			NamingPattern: `^clip\d+_(?P<group>\d+)p\.mp4$`,
+			Probe:         true,
+		},
+	}, nil))
+	defer httpServer.Close()
+	resp, err := http.Get(httpServer.URL + "/videos/probed/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	expectedBody := vodmodule.Mapping{
+		Durations: []int64{10000, 5000},
+		Sequences: []vodmodule.Sequence{
+			{
+				Language:   "eng",
+				Label:      "English",
+				AvgBitrate: &vodmodule.Bitrate{Video: 1000000, Audio: 128000},
+				Clips: []vodmodule.Clip{
+					{Type: "source", Path: "/my-bucket/videos/probed/clip1_480p.mp4"},
+					{Type: "source", Path: "/my-bucket/videos/probed/clip2_480p.mp4"},
+				},
+			},
+			{
+				Language:   "eng",
+				Label:      "English",
+				AvgBitrate: &vodmodule.Bitrate{Video: 2500000, Audio: 128000},
+				Clips: []vodmodule.Clip{
+					{Type: "source", Path: "/my-bucket/videos/probed/clip1_720p.mp4"},
+					{Type: "source", Path: "/my-bucket/videos/probed/clip2_720p.mp4"},
+				},
+			},
+		},
+	}
+	var body vodmodule.Mapping
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(body, expectedBody) {
+		t.Errorf("wrong body returned\nwant %#v\ngot  %#v\ndiff: %s", expectedBody, body, cmp.Diff(body, expectedBody))
+	}
+}
+
+func TestServerMapParts(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	httpServer := httptest.NewServer(Map(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map:        MapConfig{Probe: true},
+	}, nil))
+	defer httpServer.Close()
+	resp, err := http.Get(httpServer.URL + "/?part=ads/preroll/&part=/videos/probed/clip1_&mode=concat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	var body vodmodule.Mapping
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clips []vodmodule.Clip
+	for _, seq := range body.Sequences {
+		clips = append(clips, seq.Clips...)
+	}
+	expectedClips := []vodmodule.Clip{
+		{
+			Type:      "concat",
+			Paths:     []string{"/my-bucket/ads/preroll/ad_480p.mp4", "/my-bucket/videos/probed/clip1_480p.mp4"},
+			Durations: []int64{5000, 10000},
+		},
+		{
+			Type:      "concat",
+			Paths:     []string{"/my-bucket/ads/preroll/ad_720p.mp4", "/my-bucket/videos/probed/clip1_720p.mp4"},
+			Durations: []int64{5000, 10000},
+		},
+	}
+	if !reflect.DeepEqual(clips, expectedClips) {
+		t.Errorf("wrong clips returned\nwant %#v\ngot  %#v", expectedClips, clips)
+	}
+}
+
+func TestServerMapPartsInvalidRequest(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	for _, path := range []string{
+		"/?part=ads/preroll/&mode=playlist",
+		"/?part=ads/preroll/&part=",
+		"/videos/?part=ads/preroll/",
+	} {
+		resp, err := http.Get(addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: wrong status code\nwant %d\ngot  %d", path, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
+
+func TestServerMapTrim(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	httpServer := httptest.NewServer(Map(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map:        MapConfig{Probe: true},
+	}, nil))
+	defer httpServer.Close()
+	tests := []struct {
+		name          string
+		path          string
+		expectedClips []vodmodule.Clip
+	}{
+		{
+			"path segments",
+			"/videos/renditions//clipFrom/1000/clipTo/5000",
+			[]vodmodule.Clip{
+				{Type: "source", Path: "/my-bucket/videos/renditions/show_1080p_4500k.mp4", ClipFrom: 1000, ClipTo: 5000},
+				{Type: "source", Path: "/my-bucket/videos/renditions/show_720p_2500k.mp4", ClipFrom: 1000, ClipTo: 5000},
+				{Type: "source", Path: "/my-bucket/videos/renditions/show_audio_en_128k.m4a", ClipFrom: 1000, ClipTo: 5000},
+			},
+		},
+		{
+			"path segments after a partial prefix",
+			"/videos/probed/clip2_/clipFrom/1000",
+			[]vodmodule.Clip{
+				{Type: "source", Path: "/my-bucket/videos/probed/clip2_480p.mp4", ClipFrom: 1000},
+				{Type: "source", Path: "/my-bucket/videos/probed/clip2_720p.mp4", ClipFrom: 1000},
+			},
+		},
+		{
+			"query string",
+			"/videos/probed/clip2_?clipFrom=2000",
+			[]vodmodule.Clip{
+				{Type: "source", Path: "/my-bucket/videos/probed/clip2_480p.mp4", ClipFrom: 2000},
+				{Type: "source", Path: "/my-bucket/videos/probed/clip2_720p.mp4", ClipFrom: 2000},
+			},
+		},
+		{
+			"sequence range",
+			"/videos/probed/clip2_?range=1:1000-3000",
+			[]vodmodule.Clip{
+				{Type: "source", Path: "/my-bucket/videos/probed/clip2_480p.mp4"},
+				{Type: "source", Path: "/my-bucket/videos/probed/clip2_720p.mp4", ClipFrom: 1000, ClipTo: 3000},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := http.Get(httpServer.URL + test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+			}
+			var body vodmodule.Mapping
+			err = json.NewDecoder(resp.Body).Decode(&body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var clips []vodmodule.Clip
+			for _, seq := range body.Sequences {
+				clips = append(clips, seq.Clips...)
+			}
+			if !reflect.DeepEqual(clips, test.expectedClips) {
+				t.Errorf("wrong clips returned\nwant %#v\ngot  %#v", test.expectedClips, clips)
+			}
+		})
+	}
+}
+
+func TestServerMapTrimInvalidRange(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	httpServer := httptest.NewServer(Map(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map:        MapConfig{Probe: true},
+	}, nil))
+	defer httpServer.Close()
+	for _, path := range []string{
+		"/videos/probed/clip2_?clipFrom=abc",
+		"/videos/probed/clip2_?clipFrom=3000&clipTo=2000",
+		"/videos/probed/clip2_?clipTo=6000",
+		"/videos/probed/clip2_/clipFrom/1000?clipFrom=2000",
+		"/videos/probed/clip2_?range=2:1000-2000",
+		"/videos/probed/clip2_?range=1000-2000",
+	} {
+		resp, err := http.Get(httpServer.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: wrong status code\nwant %d\ngot  %d", path, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
+
+func TestServerMapTrimMetadataDuration(t *testing.T) {
+	t.Parallel()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Proxy:      ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	tests := []struct {
+		path           string
+		expectedStatus int
+	}{
+		{"/ads/postroll/slate?range=0:500-1500", http.StatusOK},
+		{"/ads/postroll/slate?range=0:1000-3000", http.StatusBadRequest},
+		{"/ads/postroll/slate?clipTo=3000", http.StatusBadRequest},
 	}
+	for _, test := range tests {
+		resp, err := http.Get(addr + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.expectedStatus {
+			t.Errorf("%s: wrong status code\nwant %d\ngot  %d", test.path, test.expectedStatus, resp.StatusCode)
+		}
+	}
+}
+
+func TestServerMapS3Backend(t *testing.T) {
+	t.Parallel()
+	s3Server := testhelper.NewFakeS3(testhelper.FakeObjects)
+	defer s3Server.Close()
+	addr, cleanup := testMapServer(t, Config{
+		BucketName: "my-bucket",
+		Backend: BackendConfig{
+			Buckets: map[string]string{"my-bucket": "s3"},
+			S3: S3Config{
+				Endpoint:        s3Server.URL,
+				AccessKeyID:     "access-key",
+				SecretAccessKey: "secret-key",
+				PathStyle:       true,
+			},
+		},
+		Map: MapConfig{RegexFilter: `\.mp4$`},
+	})
+	defer cleanup()
+	test := testhelper.ServerTest{
+		Method:         http.MethodGet,
+		Addr:           addr + "/videos/video/",
+		ExpectedStatus: http.StatusOK,
+		ExpectedBody: map[string]interface{}{
+			"sequences": []interface{}{
+				map[string]interface{}{"clips": []interface{}{map[string]interface{}{"type": "source", "path": "/my-bucket/videos/video/28043_1_video_1080p.mp4"}}},
+				map[string]interface{}{"clips": []interface{}{map[string]interface{}{"type": "source", "path": "/my-bucket/videos/video/video1_480p.mp4"}}},
+				map[string]interface{}{"clips": []interface{}{map[string]interface{}{"type": "source", "path": "/my-bucket/videos/video/video1_720p.mp4"}}},
+			},
+		},
+	}
+	test.Run(t)
+}
+
+func TestServerMapFallbackBuckets(t *testing.T) {
+	t.Parallel()
+	expectedBody := map[string]interface{}{
+		"sequences": []interface{}{
+			map[string]interface{}{"clips": []interface{}{map[string]interface{}{"type": "source", "path": "/my-bucket/videos/video/28043_1_video_1080p.mp4"}}},
+			map[string]interface{}{"clips": []interface{}{map[string]interface{}{"type": "source", "path": "/my-bucket/videos/video/video1_480p.mp4"}}},
+			map[string]interface{}{"clips": []interface{}{map[string]interface{}{"type": "source", "path": "/my-bucket/videos/video/video1_720p.mp4"}}},
+		},
+	}
+	tests := []struct {
+		name   string
+		config Config
+		prefix string
+	}{
+		{
+			"missing primary bucket",
+			Config{
+				BucketName: "some-bucket",
+				Fallbacks:  []FallbackBucket{{Bucket: "my-bucket"}},
+			},
+			"/videos/video/",
+		},
+		{
+			"empty listing with prefix rewrite",
+			Config{
+				BucketName: "my-bucket",
+				Fallbacks:  []FallbackBucket{{Bucket: "my-bucket", From: "legacy/", To: "videos/"}},
+			},
+			"/legacy/video/",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.config.Map.RegexFilter = `\.mp4$`
+			addr, cleanup := testMapServer(t, test.config)
+			defer cleanup()
+			testhelper.ServerTest{
+				Method:         http.MethodGet,
+				Addr:           addr + test.prefix,
+				ExpectedStatus: http.StatusOK,
+				ExpectedHeader: http.Header{"X-Gcs-Helper-Bucket": []string{"my-bucket"}},
+				ExpectedBody:   expectedBody,
+			}.Run(t)
+		})
+	}
+}
+
+func TestMapHandlerFallbackPartsNotRewritten(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	h := NewMapHandler(Config{
+		BucketName: "some-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Fallbacks:  []FallbackBucket{{Bucket: "my-bucket", From: "legacy/", To: "videos/"}},
+	}, nil)
+	req, err := h.parseRequest("/", url.Values{"part": {"legacy/parts/part1_", "videos/parts/part2_"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, bucket, status, err := h.resolve(context.Background(), req)
+	if err == nil || status != http.StatusNotFound {
+		t.Errorf("wrong result\nwant %d error\ngot  %d (%v)", http.StatusNotFound, status, err)
+	}
+	if bucket != "some-bucket" {
+		t.Errorf("wrong bucket for the fallback that can't rewrite all parts\nwant %q\ngot  %q", "some-bucket", bucket)
+	}
+}
+
+func TestServerMapUpstreamEndpoint(t *testing.T) {
+	t.Parallel()
+	server, endpoint := testEndpointServer(t)
+	defer server.Stop()
+	cfg := Config{
+		BucketName: "my-bucket",
+		Client: ClientConfig{
+			Timeout:   time.Second,
+			Endpoint:  endpoint,
+			Anonymous: true,
+		},
+		Map: MapConfig{RegexFilter: `1080p\.mp4$`},
+	}
+	hc, err := cfg.Client.HTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := cfg.Client.StorageClient(context.Background(), hc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(Map(cfg, client))
+	defer httpServer.Close()
+	testhelper.ServerTest{
+		Method:         http.MethodGet,
+		Addr:           httpServer.URL + "/videos/video/",
+		ExpectedStatus: http.StatusOK,
+		ExpectedBody: map[string]interface{}{
+			"sequences": []interface{}{
+				map[string]interface{}{"clips": []interface{}{map[string]interface{}{"type": "source", "path": "/my-bucket/videos/video/28043_1_video_1080p.mp4"}}},
+			},
+		},
+	}.Run(t)
 }
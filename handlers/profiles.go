@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// FilterProfile is a named set of filters that can be selected per map
+// request, so different players can get different rendition ladders.
+//
+// Include replaces GCS_HELPER_MAP_REGEX_FILTER when set, while objects that
+// match Exclude are never mapped. Both patterns apply to the base name of
+// objects.
+type FilterProfile struct {
+	Include string `json:"include"`
+	Exclude string `json:"exclude"`
+}
+
+// FilterProfiles maps the name of profiles to their filters. It's decoded
+// from JSON objects, as in {"mobile": {"include": "(240|360)p\\.mp4$"}}.
+type FilterProfiles map[string]FilterProfile
+
+// Decode implements envconfig.Decoder.
+func (p *FilterProfiles) Decode(value string) error {
+	profiles := FilterProfiles{}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&profiles); err != nil {
+		return fmt.Errorf("invalid filter profiles: %v", err)
+	}
+	*p = profiles
+	return nil
+}
+
+// filterProfile is a FilterProfile with compiled patterns.
+type filterProfile struct {
+	name    string
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+func (p FilterProfiles) compile() (map[string]filterProfile, error) {
+	profiles := make(map[string]filterProfile, len(p))
+	for name, profile := range p {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid filter profile name %q", name)
+		}
+		var (
+			compiled = filterProfile{name: name}
+			err      error
+		)
+		if profile.Include != "" {
+			if compiled.include, err = regexp.Compile(profile.Include); err != nil {
+				return nil, fmt.Errorf("invalid include pattern in filter profile %q: %v", name, err)
+			}
+		}
+		if profile.Exclude != "" {
+			if compiled.exclude, err = regexp.Compile(profile.Exclude); err != nil {
+				return nil, fmt.Errorf("invalid exclude pattern in filter profile %q: %v", name, err)
+			}
+		}
+		profiles[name] = compiled
+	}
+	return profiles, nil
+}
+
+// selectProfile returns the profile selected in the request, either in the
+// first segment of the path or in the "profile" query string parameter, along
+// with the remaining path. Unknown profiles in the query string are an error,
+// while unknown path segments are part of the prefix.
+func selectProfile(profiles map[string]filterProfile, path string, query url.Values) (string, *filterProfile, error) {
+	name := query.Get("profile")
+	if segments := strings.SplitN(path, "/", 2); len(segments) == 2 {
+		if profile, ok := profiles[segments[0]]; ok {
+			if name != "" && name != segments[0] {
+				return "", nil, fmt.Errorf("conflicting filter profiles %q and %q", segments[0], name)
+			}
+			return segments[1], &profile, nil
+		}
+	}
+	if name == "" {
+		return path, nil, nil
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown filter profile %q", name)
+	}
+	return path, &profile, nil
+}
@@ -2,14 +2,19 @@ package handlers
 
 import (
 	"context"
-	"fmt"
-	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/backend"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
 	"github.com/sirupsen/logrus"
 )
 
+// tokenParam is the query string parameter holding the tokens of clip paths.
+const tokenParam = "token"
+
 type codeWrapper struct {
 	code int
 	http.ResponseWriter
@@ -21,15 +26,19 @@ func (w *codeWrapper) WriteHeader(code int) {
 }
 
 type proxyHandler struct {
-	config Config
-	logger *logrus.Logger
-	hc     *http.Client
+	config  Config
+	logger  *logrus.Logger
+	backend backend.Backend
+	signer  *vodmodule.TokenSigner
 }
 
 func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	resp := codeWrapper{ResponseWriter: w}
-	var err error
+	var (
+		err      error
+		servedBy string
+	)
 
 	defer r.Body.Close()
 	defer func() {
@@ -41,6 +50,9 @@ func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				"proxyEndpoint": h.config.Proxy.Endpoint,
 				"response":      resp.code,
 			}
+			if servedBy != "" {
+				fields["bucket"] = servedBy
+			}
 			for _, header := range h.config.Proxy.LogHeaders {
 				if value := r.Header.Get(header); value != "" {
 					fields["ReqHeader/"+header] = value
@@ -66,41 +78,67 @@ func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), h.config.Proxy.Timeout)
 	defer cancel()
 
-	host := "storage.googleapis.com"
-	if !h.config.Proxy.BucketOnPath {
-		host = h.config.BucketName + "." + host
+	bucket, object := h.config.BucketName, strings.TrimPrefix(r.URL.Path, "/")
+	if h.config.Proxy.BucketOnPath {
+		parts := strings.SplitN(object, "/", 2)
+		bucket, object = parts[0], ""
+		if len(parts) > 1 {
+			object = parts[1]
+		}
 	}
-	url := fmt.Sprintf("https://%s%s", host, r.URL.RequestURI())
-	// no support for request body, do we care? :)
-	gcsReq, err := http.NewRequest(r.Method, url, nil)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	r = r.WithContext(ctx)
+	targets := h.config.bucketChain(bucket, object)
+	if h.signer != nil && !h.verifyToken(r, targets) {
+		http.Error(&resp, "invalid token", http.StatusForbidden)
 		return
 	}
-	gcsReq = gcsReq.WithContext(ctx)
-	for name, values := range r.Header {
-		for _, value := range values {
-			gcsReq.Header.Add(name, value)
+	for i, target := range targets {
+		servedBy = target.bucket
+		if i == len(targets)-1 {
+			resp.Header().Set(bucketHeader, target.bucket)
+			err = h.backend.Serve(&resp, r, target.bucket, target.name)
+			break
+		}
+		fw := newFallbackWriter(&resp)
+		fw.Header().Set(bucketHeader, target.bucket)
+		err = h.backend.Serve(fw, r, target.bucket, target.name)
+		if !fw.notFound {
+			break
 		}
 	}
-	gcsResp, err := h.hc.Do(gcsReq)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer gcsResp.Body.Close()
+}
 
-	for name, values := range gcsResp.Header {
-		for _, value := range values {
-			resp.Header().Add(name, value)
+// verifyToken checks the token of the request, which may have been signed
+// for any of the targets (as mappings of fallback buckets refer to their own
+// objects), and removes it from the query string before reaching the backend.
+func (h *proxyHandler) verifyToken(r *http.Request, targets []bucketTarget) bool {
+	query := r.URL.Query()
+	token := query.Get(tokenParam)
+	query.Del(tokenParam)
+	r.URL.RawQuery = query.Encode()
+	if token == "" {
+		return false
+	}
+	for _, target := range targets {
+		if h.signer.Verify(target.bucket, target.name, token) == nil {
+			return true
 		}
 	}
-	resp.WriteHeader(gcsResp.StatusCode)
-	io.Copy(resp, gcsResp.Body)
+	return false
 }
 
-// Proxy returns the proxy handler.
+// Proxy returns the proxy handler. When GCS_HELPER_MAP_TOKEN_SECRET is set,
+// requests must carry a valid token for the object (see
+// vodmodule.TokenSigner).
 func Proxy(c Config, hc *http.Client) http.Handler {
 	logger := c.Logger()
-	return &proxyHandler{logger: logger, hc: hc, config: c}
+	var client *storage.Client
+	if c.Proxy.Mode == proxyModeClient && c.NeedsGCS() {
+		var err error
+		client, err = c.Client.StorageClient(context.Background(), hc)
+		if err != nil {
+			logger.WithError(err).Error("failed to create storage client instance for the proxy")
+		}
+	}
+	return &proxyHandler{logger: logger, backend: c.newBackend(client, hc), config: c, signer: c.Map.tokenSigner()}
 }
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/NYTimes/gcs-helper/v3/internal/testhelper"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+	"github.com/fsouza/fake-gcs-server/fakestorage"
 )
 
 func TestProxyHandler(t *testing.T) {
@@ -155,3 +158,282 @@ func TestServerProxyHandlerBucketNotFound(t *testing.T) {
 		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusNotFound, resp.StatusCode)
 	}
 }
+
+func TestProxyHandlerLocalBackend(t *testing.T) {
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	httpServer := httptest.NewServer(Proxy(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Proxy:      ProxyConfig{BucketOnPath: true, Timeout: time.Second},
+	}, nil))
+	defer httpServer.Close()
+	tests := []testhelper.ServerTest{
+		{
+			TestCase:       "download file",
+			Method:         http.MethodGet,
+			Addr:           httpServer.URL + "/your-bucket/musics/music/music3.txt",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Content-Length": []string{"9"},
+			},
+			ExpectedBody: "wait what",
+		},
+		{
+			TestCase: "download file - range",
+			Method:   http.MethodGet,
+			Addr:     httpServer.URL + "/my-bucket/musics/music/music2.txt",
+			ReqHeader: http.Header{
+				"Range": []string{"bytes=5-9"},
+			},
+			ExpectedStatus: http.StatusPartialContent,
+			ExpectedHeader: http.Header{
+				"Content-Range": []string{"bytes 5-9/16"},
+			},
+			ExpectedBody: "nicer",
+		},
+		{
+			TestCase:       "object not found",
+			Method:         http.MethodGet,
+			Addr:           httpServer.URL + "/my-bucket/musics/music/some-music.txt",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   "not found\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.TestCase, test.Run)
+	}
+}
+
+func TestProxyHandlerTokens(t *testing.T) {
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	httpServer := httptest.NewServer(Proxy(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map:        MapConfig{TokenSecret: "token-secret", ClipPath: "{proxy}{bucket}/{object}?token={token}"},
+		Proxy:      ProxyConfig{BucketOnPath: true, Timeout: time.Second},
+	}, nil))
+	defer httpServer.Close()
+	signer := vodmodule.TokenSigner{Secret: []byte("token-secret")}
+	token := signer.Sign("my-bucket", "musics/music/music2.txt")
+	tests := []testhelper.ServerTest{
+		{
+			TestCase:       "valid token",
+			Method:         http.MethodGet,
+			Addr:           httpServer.URL + "/my-bucket/musics/music/music2.txt?token=" + token,
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "some nicer music",
+		},
+		{
+			TestCase:       "missing token",
+			Method:         http.MethodGet,
+			Addr:           httpServer.URL + "/my-bucket/musics/music/music2.txt",
+			ExpectedStatus: http.StatusForbidden,
+			ExpectedBody:   "invalid token\n",
+		},
+		{
+			TestCase:       "token of another object",
+			Method:         http.MethodGet,
+			Addr:           httpServer.URL + "/my-bucket/musics/music/music1.txt?token=" + token,
+			ExpectedStatus: http.StatusForbidden,
+			ExpectedBody:   "invalid token\n",
+		},
+		{
+			TestCase:       "token signed with another secret",
+			Method:         http.MethodGet,
+			Addr:           httpServer.URL + "/my-bucket/musics/music/music2.txt?token=" + (&vodmodule.TokenSigner{Secret: []byte("other")}).Sign("my-bucket", "musics/music/music2.txt"),
+			ExpectedStatus: http.StatusForbidden,
+			ExpectedBody:   "invalid token\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.TestCase, test.Run)
+	}
+}
+
+func TestServerProxyHandlerS3Bucket(t *testing.T) {
+	s3Server := testhelper.NewFakeS3([]fakestorage.Object{
+		{BucketName: "s3-bucket", Name: "musics/music/music3.txt", Content: []byte("from s3")},
+	})
+	defer s3Server.Close()
+	addr, cleanup := testProxyServer(t, Config{
+		BucketName: "my-bucket",
+		Backend: BackendConfig{
+			Buckets: map[string]string{"s3-bucket": "s3"},
+			S3: S3Config{
+				Endpoint:        s3Server.URL,
+				AccessKeyID:     "access-key",
+				SecretAccessKey: "secret-key",
+				PathStyle:       true,
+			},
+		},
+		Proxy: ProxyConfig{
+			BucketOnPath: true,
+			Timeout:      time.Second,
+		},
+	})
+	defer cleanup()
+	tests := []testhelper.ServerTest{
+		{
+			TestCase:       "gcs bucket",
+			Method:         http.MethodGet,
+			Addr:           addr + "/your-bucket/musics/music/music3.txt",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "wait what",
+		},
+		{
+			TestCase:       "s3 bucket",
+			Method:         http.MethodGet,
+			Addr:           addr + "/s3-bucket/musics/music/music3.txt",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   "from s3",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.TestCase, test.Run)
+	}
+}
+
+func TestProxyHandlerFallbackBuckets(t *testing.T) {
+	addr, cleanup := testProxyServer(t, Config{
+		BucketName: "my-bucket",
+		Fallbacks: []FallbackBucket{
+			{Bucket: "some-bucket"},
+			{Bucket: "your-bucket", From: "legacy/", To: "musics/"},
+		},
+		Proxy: ProxyConfig{Timeout: time.Second},
+	})
+	defer cleanup()
+	tests := []testhelper.ServerTest{
+		{
+			TestCase:       "served by the primary bucket",
+			Method:         http.MethodGet,
+			Addr:           addr + "/musics/music/music3.txt",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{"X-Gcs-Helper-Bucket": []string{"my-bucket"}},
+			ExpectedBody:   "some even nicer music",
+		},
+		{
+			TestCase:       "served by the fallback bucket",
+			Method:         http.MethodGet,
+			Addr:           addr + "/legacy/music/music3.txt",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"X-Gcs-Helper-Bucket": []string{"your-bucket"},
+				"Content-Length":      []string{"9"},
+			},
+			ExpectedBody: "wait what",
+		},
+		{
+			TestCase:       "served by the fallback bucket - HEAD",
+			Method:         http.MethodHead,
+			Addr:           addr + "/legacy/music/music3.txt",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{"X-Gcs-Helper-Bucket": []string{"your-bucket"}},
+		},
+		{
+			TestCase:       "not found in any bucket",
+			Method:         http.MethodGet,
+			Addr:           addr + "/legacy/music/music1.txt",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   "not found\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.TestCase, test.Run)
+	}
+}
+
+func TestProxyHandlerClientMode(t *testing.T) {
+	addr, cleanup := testProxyServer(t, Config{
+		BucketName: "my-bucket",
+		Proxy: ProxyConfig{
+			Mode:    "client",
+			Timeout: time.Second,
+		},
+	})
+	defer cleanup()
+	tests := []testhelper.ServerTest{
+		{
+			TestCase:       "download file",
+			Method:         http.MethodGet,
+			Addr:           addr + "/musics/music/music1.txt",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Accept-Ranges":  []string{"bytes"},
+				"Content-Length": []string{"15"},
+				"Content-Type":   []string{"text/plain; charset=utf-8"},
+			},
+			ExpectedBody: "some nice music",
+		},
+		{
+			// the fake server handles the end of ranges as exclusive, so
+			// only open-ended ranges are tested.
+			TestCase: "download file - range",
+			Method:   http.MethodGet,
+			Addr:     addr + "/musics/music/music2.txt",
+			ReqHeader: http.Header{
+				"Range": []string{"bytes=6-"},
+			},
+			ExpectedStatus: http.StatusPartialContent,
+			ExpectedHeader: http.Header{
+				"Content-Length": []string{"10"},
+				"Content-Range":  []string{"bytes 6-15/16"},
+			},
+			ExpectedBody: "icer music",
+		},
+		{
+			TestCase:       "file attrs",
+			Method:         http.MethodHead,
+			Addr:           addr + "/musics/music/music2.txt",
+			ExpectedStatus: http.StatusOK,
+			ExpectedHeader: http.Header{
+				"Content-Length": []string{"16"},
+			},
+			ExpectedBody: "",
+		},
+		{
+			TestCase:       "download file - object not found",
+			Method:         http.MethodGet,
+			Addr:           addr + "/musics/music/some-music.txt",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   "not found\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.TestCase, test.Run)
+	}
+}
+
+func TestProxyHandlerUpstreamEndpoint(t *testing.T) {
+	server, endpoint := testEndpointServer(t)
+	defer server.Stop()
+	for _, mode := range []string{"raw", "client"} {
+		mode := mode
+		t.Run(mode, func(t *testing.T) {
+			cfg := Config{
+				BucketName: "my-bucket",
+				Client: ClientConfig{
+					Timeout:   time.Second,
+					Endpoint:  endpoint,
+					PathStyle: true,
+					Anonymous: true,
+				},
+				Proxy: ProxyConfig{Mode: mode, Timeout: time.Second},
+			}
+			hc, err := cfg.Client.HTTPClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			httpServer := httptest.NewServer(Proxy(cfg, hc))
+			defer httpServer.Close()
+			testhelper.ServerTest{
+				Method:         http.MethodGet,
+				Addr:           httpServer.URL + "/musics/music/music1.txt",
+				ExpectedStatus: http.StatusOK,
+				ExpectedBody:   "some nice music",
+			}.Run(t)
+		})
+	}
+}
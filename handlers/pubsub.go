@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// Event types of GCS notifications.
+const (
+	eventFinalize       = "OBJECT_FINALIZE"
+	eventDelete         = "OBJECT_DELETE"
+	eventArchive        = "OBJECT_ARCHIVE"
+	eventMetadataUpdate = "OBJECT_METADATA_UPDATE"
+)
+
+// pushRequest is the body of Pub/Sub push requests. encoding/json decodes the
+// base64-encoded data.
+type pushRequest struct {
+	Message struct {
+		Attributes map[string]string `json:"attributes"`
+		Data       []byte            `json:"data"`
+		MessageID  string            `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// notificationObject is the object resource sent in the data of GCS
+// notifications (in the JSON_API_V1 payload format).
+type notificationObject struct {
+	Bucket         string            `json:"bucket"`
+	Name           string            `json:"name"`
+	ContentType    string            `json:"contentType"`
+	Etag           string            `json:"etag"`
+	Size           int64             `json:"size,string"`
+	Generation     int64             `json:"generation,string"`
+	Metageneration int64             `json:"metageneration,string"`
+	Metadata       map[string]string `json:"metadata"`
+}
+
+func (o notificationObject) attrs() *storage.ObjectAttrs {
+	return &storage.ObjectAttrs{
+		Bucket:         o.Bucket,
+		Name:           o.Name,
+		ContentType:    o.ContentType,
+		Etag:           o.Etag,
+		Size:           o.Size,
+		Generation:     o.Generation,
+		Metageneration: o.Metageneration,
+		Metadata:       o.Metadata,
+	}
+}
+
+// servePubSub handles GCS notifications delivered by Pub/Sub push
+// subscriptions. Every change invalidates the cached mappings that include
+// the object. Deleted objects are removed from the probe cache, while new
+// objects are probed right away, so the next mapping finds them cached.
+//
+// Events of other types and malformed messages are logged, acknowledged and
+// ignored, as Pub/Sub redelivers messages that get any other response until
+// they expire.
+func (h *MapHandler) servePubSub(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var push pushRequest
+	if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+		h.logger.WithError(err).Warn("ignoring invalid push request")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	attrs := push.Message.Attributes
+	bucket, object, eventType := attrs["bucketId"], attrs["objectId"], attrs["eventType"]
+	if bucket == "" || object == "" || eventType == "" {
+		h.logger.WithField("messageId", push.Message.MessageID).Warn("ignoring notification without bucketId, objectId or eventType")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	logger := h.logger.WithFields(logrus.Fields{
+		"bucket":    bucket,
+		"object":    object,
+		"event":     eventType,
+		"messageId": push.Message.MessageID,
+	})
+	switch eventType {
+	case eventFinalize, eventDelete, eventArchive, eventMetadataUpdate:
+	default:
+		logger.Debug("ignoring notification")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	n := h.Invalidate(bucket, object)
+	logger = logger.WithField("invalidated", n)
+	if h.prober != nil {
+		switch eventType {
+		case eventDelete, eventArchive:
+			h.prober.Invalidate(bucket, object)
+		case eventFinalize:
+			obj := notificationObject{Bucket: bucket, Name: object}
+			if len(push.Message.Data) > 0 {
+				if err := json.Unmarshal(push.Message.Data, &obj); err != nil {
+					logger.WithError(err).Warn("ignoring invalid notification data")
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+			if obj.Size > 0 {
+				if err := h.prober.Warm(r.Context(), h.backends.Bucket(bucket), obj.attrs()); err != nil {
+					logger.WithError(err).Warn("failed to probe new object")
+				}
+			}
+		}
+	}
+	logger.Debug("handled notification")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMapHandlerPubSub(t *testing.T) {
+	t.Parallel()
+	root, cleanupRoot := testLocalRoot(t)
+	defer cleanupRoot()
+	h := NewMapHandler(Config{
+		BucketName: "my-bucket",
+		Backend:    BackendConfig{Type: "local", LocalRoot: root},
+		Map: MapConfig{
+			CacheTTL:   time.Hour,
+			Probe:      true,
+			AdminToken: "admin-token",
+		},
+	}, nil)
+	mapServer := httptest.NewServer(h)
+	defer mapServer.Close()
+	adminServer := httptest.NewServer(h.AdminHandler())
+	defer adminServer.Close()
+
+	if n := testCountSequences(t, mapServer.URL+"/videos/probed/"); n != 4 {
+		t.Fatalf("wrong number of sequences\nwant 4\ngot  %d", n)
+	}
+	err := ioutil.WriteFile(filepath.Join(root, "my-bucket", "videos", "probed", "clip1_1080p.mp4"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := ioutil.ReadFile("testdata/pubsub-finalize.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(adminServer.URL+"/pubsub?token=admin-token", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if n := testCountSequences(t, mapServer.URL+"/videos/probed/"); n != 5 {
+		t.Errorf("mapping wasn't invalidated\nwant 5 sequences\ngot  %d", n)
+	}
+}
+
+func TestMapHandlerPubSubInvalidRequests(t *testing.T) {
+	t.Parallel()
+	h := NewMapHandler(Config{BucketName: "my-bucket", Map: MapConfig{CacheTTL: time.Hour, Probe: true}}, nil)
+	adminServer := httptest.NewServer(h.AdminHandler())
+	defer adminServer.Close()
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+	}{
+		{"method not allowed", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"invalid JSON", http.MethodPost, `{"message":`, http.StatusNoContent},
+		{"missing attributes", http.MethodPost, `{"message": {"attributes": {"bucketId": "my-bucket"}}}`, http.StatusNoContent},
+		{
+			"invalid data",
+			http.MethodPost,
+			`{"message": {"attributes": {"bucketId": "my-bucket", "objectId": "a.mp4", "eventType": "OBJECT_FINALIZE"}, "data": "not base64"}}`,
+			http.StatusNoContent,
+		},
+		{
+			"ignored event",
+			http.MethodPost,
+			`{"message": {"attributes": {"bucketId": "my-bucket", "objectId": "a.mp4", "eventType": "OBJECT_UNKNOWN"}}}`,
+			http.StatusNoContent,
+		},
+		{
+			"invalid notification data",
+			http.MethodPost,
+			`{"message": {"attributes": {"bucketId": "my-bucket", "objectId": "a.mp4", "eventType": "OBJECT_FINALIZE"}, "data": "bm90IGpzb24="}}`,
+			http.StatusNoContent,
+		},
+		{
+			"delete",
+			http.MethodPost,
+			`{"message": {"attributes": {"bucketId": "my-bucket", "objectId": "a.mp4", "eventType": "OBJECT_DELETE"}}}`,
+			http.StatusNoContent,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest(test.method, adminServer.URL+"/pubsub", strings.NewReader(test.body))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.expectedStatus {
+				t.Errorf("wrong status code\nwant %d\ngot  %d", test.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
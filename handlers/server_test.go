@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"io/ioutil"
+	"net"
 	"net/http/httptest"
+	"os"
 	"testing"
 
 	"github.com/NYTimes/gcs-helper/v3/internal/testhelper"
@@ -41,3 +43,38 @@ func testProxyServer(t *testing.T, cfg Config) (string, func()) {
 		server.Stop()
 	}
 }
+
+func testLocalRoot(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "gcs-helper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testhelper.WriteObjects(dir, testhelper.FakeObjects)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// testEndpointServer starts fake-gcs-server with a listener, so it can be
+// reached through the configured upstream endpoint.
+func testEndpointServer(t *testing.T) (*fakestorage.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close()
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		InitialObjects: testhelper.FakeObjects,
+		Scheme:         "http",
+		Host:           "127.0.0.1",
+		Port:           uint16(addr.Port),
+		PublicHost:     addr.String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, server.URL()
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule"
+)
+
+// trimRequest contains the ranges requested in a map request, either in the
+// query string ("clipFrom", "clipTo" and "range") or in path segments, as in
+// "/videos/video1/clipFrom/10000/clipTo/20000".
+type trimRequest struct {
+	all       vodmodule.ClipRange
+	sequences []sequenceRange
+}
+
+// sequenceRange is a range that applies to a single sequence, identified by
+// its id or by its index.
+type sequenceRange struct {
+	sequence string
+	vodmodule.ClipRange
+}
+
+// parseTrimRequest extracts the ranges from the request, returning the path
+// without range segments.
+func parseTrimRequest(path string, query url.Values) (string, trimRequest, error) {
+	var t trimRequest
+	path, segments, err := splitClipSegments(path)
+	if err != nil {
+		return "", t, err
+	}
+	t.all = segments
+	for _, name := range []string{"clipFrom", "clipTo"} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		if !segments.IsZero() {
+			return "", t, fmt.Errorf("%s can't be set in both the path and the query string", name)
+		}
+		ms, err := parseMillis(name, value)
+		if err != nil {
+			return "", t, err
+		}
+		if name == "clipFrom" {
+			t.all.From = ms
+		} else {
+			t.all.To = ms
+		}
+	}
+	for _, value := range query["range"] {
+		r, err := parseSequenceRange(value)
+		if err != nil {
+			return "", t, err
+		}
+		t.sequences = append(t.sequences, r)
+	}
+	return path, t, nil
+}
+
+// splitClipSegments removes trailing "clipFrom/<ms>" and "clipTo/<ms>" segments
+// from the path, along with the slash before them, so the prefix is kept as is
+// ("videos/clip1_/clipFrom/1000" maps "videos/clip1_").
+func splitClipSegments(path string) (string, vodmodule.ClipRange, error) {
+	var r vodmodule.ClipRange
+	segments := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	n := len(segments)
+	for n >= 2 && (segments[n-2] == "clipFrom" || segments[n-2] == "clipTo") {
+		ms, err := parseMillis(segments[n-2], segments[n-1])
+		if err != nil {
+			return "", r, err
+		}
+		if segments[n-2] == "clipFrom" {
+			r.From = ms
+		} else {
+			r.To = ms
+		}
+		n -= 2
+	}
+	if n == len(segments) {
+		return path, r, nil
+	}
+	return strings.Join(segments[:n], "/"), r, nil
+}
+
+// parseSequenceRange parses values in the format "<sequence>:<from>-<to>",
+// where "to" is optional.
+func parseSequenceRange(value string) (sequenceRange, error) {
+	var r sequenceRange
+	i := strings.LastIndex(value, ":")
+	if i <= 0 {
+		return r, fmt.Errorf("invalid range %q: must be in the format sequence:from-to", value)
+	}
+	r.sequence = value[:i]
+	bounds := strings.SplitN(value[i+1:], "-", 2)
+	if len(bounds) != 2 {
+		return r, fmt.Errorf("invalid range %q: must be in the format sequence:from-to", value)
+	}
+	var err error
+	if r.From, err = parseMillis("range", bounds[0]); err != nil {
+		return r, err
+	}
+	if bounds[1] != "" {
+		if r.To, err = parseMillis("range", bounds[1]); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+func parseMillis(name, value string) (int64, error) {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative number of milliseconds", name, value)
+	}
+	return ms, nil
+}
+
+func (t trimRequest) isZero() bool {
+	return t.all.IsZero() && len(t.sequences) == 0
+}
+
+// apply trims the mapping. Errors wrapping vodmodule.ErrInvalidRange are
+// caused by ranges that don't match the mapping.
+func (t trimRequest) apply(m *vodmodule.Mapping) error {
+	for _, r := range t.sequences {
+		i, err := sequenceIndex(m, r.sequence)
+		if err != nil {
+			return err
+		}
+		if err := m.TrimSequence(i, r.ClipRange); err != nil {
+			return err
+		}
+	}
+	return m.Trim(t.all)
+}
+
+// sequenceIndex finds the sequence with the given id, falling back to the
+// index of the sequence.
+func sequenceIndex(m *vodmodule.Mapping, sequence string) (int, error) {
+	for i, seq := range m.Sequences {
+		if seq.ID == sequence {
+			return i, nil
+		}
+	}
+	i, err := strconv.Atoi(sequence)
+	if err != nil || i < 0 || i >= len(m.Sequences) {
+		return 0, fmt.Errorf("%w: sequence %q not found", vodmodule.ErrInvalidRange, sequence)
+	}
+	return i, nil
+}
@@ -0,0 +1,120 @@
+package testhelper
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// MP4Options describes the synthetic MP4 file generated by FakeMP4. Durations
+// are in milliseconds and bitrates in bits per second.
+type MP4Options struct {
+	Duration int64
+
+	// The video track is omitted when Height is zero.
+	Width        int
+	Height       int
+	VideoBitrate int64
+
+	// The audio track is omitted when AudioBitrate is zero.
+	AudioBitrate int64
+	Language     string
+
+	// MoovAtEnd places the moov box after the media data, as in files that
+	// aren't optimized for streaming.
+	MoovAtEnd bool
+
+	// SegmentIndex adds a sidx box after the moov box, as in fragmented
+	// files prepared for the on-demand profile of DASH.
+	SegmentIndex bool
+}
+
+// FakeMP4 generates an MP4 file with the metadata described by opts. The media
+// data is filled with zeros, so the file isn't playable.
+func FakeMP4(opts MP4Options) []byte {
+	ftyp := mp4Box("ftyp", []byte("isom"), u32(0x200), []byte("isomavc1mp41"))
+	mdat := mp4Box("mdat", make([]byte, 1024))
+
+	var traks [][]byte
+	trackID := uint32(1)
+	if opts.Height > 0 {
+		avcC := mp4Box("avcC", []byte{1, 0x64, 0x00, 0x1f, 0xff, 0xe0})
+		entry := make([]byte, 78)
+		binary.BigEndian.PutUint16(entry[24:], uint16(opts.Width))
+		binary.BigEndian.PutUint16(entry[26:], uint16(opts.Height))
+		stsd := mp4Box("stsd", u32(0), u32(1), mp4Box("avc1", entry, avcC))
+		traks = append(traks, mp4Trak(trackID, "vide", "und", opts, stsd, opts.VideoBitrate))
+		trackID++
+	}
+	if opts.AudioBitrate > 0 {
+		decoderSpecific := []byte{0x05, 2, 0x12, 0x10}
+		decoderConfig := append([]byte{0x04, byte(13 + len(decoderSpecific)), 0x40, 0x15, 0, 0, 0}, make([]byte, 8)...)
+		decoderConfig = append(decoderConfig, decoderSpecific...)
+		es := append([]byte{0x03, byte(3 + len(decoderConfig)), 0, 1, 0}, decoderConfig...)
+		esds := mp4Box("esds", u32(0), es)
+		entry := make([]byte, 28)
+		binary.BigEndian.PutUint16(entry[16:], 2)
+		binary.BigEndian.PutUint16(entry[18:], 16)
+		binary.BigEndian.PutUint32(entry[24:], 48000<<16)
+		stsd := mp4Box("stsd", u32(0), u32(1), mp4Box("mp4a", entry, esds))
+		lang := opts.Language
+		if lang == "" {
+			lang = "und"
+		}
+		traks = append(traks, mp4Trak(trackID, "soun", lang, opts, stsd, opts.AudioBitrate))
+		trackID++
+	}
+
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], uint32(opts.Duration))
+	binary.BigEndian.PutUint32(mvhd[20:], 0x00010000)
+	binary.BigEndian.PutUint32(mvhd[96:], trackID)
+	moov := mp4Box("moov", append([][]byte{mp4Box("mvhd", mvhd)}, traks...)...)
+
+	if opts.MoovAtEnd {
+		return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+	}
+	if opts.SegmentIndex {
+		sidx := mp4Box("sidx", make([]byte, 32))
+		return bytes.Join([][]byte{ftyp, moov, sidx, mdat}, nil)
+	}
+	return bytes.Join([][]byte{ftyp, moov, mdat}, nil)
+}
+
+func mp4Trak(id uint32, handler, lang string, opts MP4Options, stsd []byte, bitrate int64) []byte {
+	tkhd := make([]byte, 84)
+	tkhd[3] = 3 // enabled and in movie
+	binary.BigEndian.PutUint32(tkhd[12:], id)
+	binary.BigEndian.PutUint32(tkhd[20:], uint32(opts.Duration))
+	if handler == "vide" {
+		binary.BigEndian.PutUint32(tkhd[76:], uint32(opts.Width)<<16)
+		binary.BigEndian.PutUint32(tkhd[80:], uint32(opts.Height)<<16)
+	}
+
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[12:], 1000)
+	binary.BigEndian.PutUint32(mdhd[16:], uint32(opts.Duration))
+	binary.BigEndian.PutUint16(mdhd[20:], uint16(lang[0]-0x60)<<10|uint16(lang[1]-0x60)<<5|uint16(lang[2]-0x60))
+
+	hdlr := append(append(u32(0), u32(0)...), []byte(handler)...)
+	hdlr = append(hdlr, make([]byte, 13)...)
+
+	// a single sample holding all the media data of the track.
+	stsz := mp4Box("stsz", u32(0), u32(uint32(bitrate*opts.Duration/8000)), u32(1))
+
+	stbl := mp4Box("stbl", stsd, stsz)
+	minf := mp4Box("minf", stbl)
+	mdia := mp4Box("mdia", mp4Box("mdhd", mdhd), mp4Box("hdlr", hdlr), minf)
+	return mp4Box("trak", mp4Box("tkhd", tkhd), mdia)
+}
+
+func mp4Box(typ string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	return append(append(u32(uint32(8+len(data))), typ...), data...)
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
@@ -1,6 +1,12 @@
 package testhelper
 
-import "github.com/fsouza/fake-gcs-server/fakestorage"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+)
 
 var FakeObjects = []fakestorage.Object{
 	{
@@ -75,4 +81,162 @@ var FakeObjects = []fakestorage.Object{
 		BucketName: "my-bucket",
 		Name:       "videos/video/77071_1_caption_wg_240p_001f8ea7-749b-4d43-7bd5-b357e4e24f32.srt",
 	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/renditions/show_1080p_4500k.mp4",
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/renditions/show_720p_2500k.mp4",
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/renditions/show_audio_en_128k.m4a",
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/parts/part1_480p.mp4",
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/parts/part1_720p.mp4",
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/parts/part2_480p.mp4",
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/parts/part2_720p.mp4",
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/edited/captions_en.vtt",
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/edited/video_480p.mp4",
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/edited/mapping.json",
+		Content: []byte(`{
+			"id": "edited",
+			"sequences": [
+				{"id": "captions_en", "label": "English (CC)"},
+				{"id": "audio", "clips": [{"type": "source", "path": "/my-bucket/videos/edited/video_480p.mp4", "tracks": "a1"}]}
+			]
+		}`),
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/trimmed/video_480p.mp4",
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/trimmed/mapping.json",
+		Content: []byte(`{
+			"mode": "override",
+			"sequences": [
+				{"clips": [{"type": "source", "path": "/my-bucket/videos/trimmed/video_480p.mp4", "clipFrom": 5000, "clipTo": 20000}]}
+			]
+		}`),
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/broken/video_480p.mp4",
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/broken/mapping.json",
+		Content:    []byte(`{"sequences": [{"clips": [{"type": "source", "file": "video_480p.mp4"}]}]}`),
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/probed/clip1_480p.mp4",
+		Content:    FakeMP4(MP4Options{Duration: 10000, Width: 854, Height: 480, VideoBitrate: 1000000, AudioBitrate: 128000, Language: "eng"}),
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/probed/clip1_720p.mp4",
+		Content:    FakeMP4(MP4Options{Duration: 10040, Width: 1280, Height: 720, VideoBitrate: 2500000, AudioBitrate: 128000, Language: "eng", MoovAtEnd: true}),
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/probed/clip2_480p.mp4",
+		Content:    FakeMP4(MP4Options{Duration: 5000, Width: 854, Height: 480, VideoBitrate: 1000000, AudioBitrate: 128000, Language: "eng"}),
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "videos/probed/clip2_720p.mp4",
+		Content:    FakeMP4(MP4Options{Duration: 5000, Width: 1280, Height: 720, VideoBitrate: 2500000, AudioBitrate: 128000, Language: "eng"}),
+	},
+	{
+		BucketName:  "my-bucket",
+		Name:        "videos/published/captions_es.vtt",
+		ContentType: "text/vtt",
+		Metadata:    map[string]string{"status": "ready", "language": "es"},
+	},
+	{
+		BucketName:  "my-bucket",
+		Name:        "videos/published/thumbnail.jpg",
+		ContentType: "image/jpeg",
+		Metadata:    map[string]string{"status": "ready"},
+	},
+	{
+		BucketName:  "my-bucket",
+		Name:        "videos/published/video_1080p.mp4",
+		ContentType: "video/mp4",
+	},
+	{
+		BucketName:  "my-bucket",
+		Name:        "videos/published/video_480p.mp4",
+		ContentType: "video/mp4",
+		Metadata:    map[string]string{"status": "ready", "language": "es"},
+	},
+	{
+		BucketName:  "my-bucket",
+		Name:        "videos/published/video_720p.mp4",
+		ContentType: "video/mp4; codecs=\"avc1.64001f\"",
+		Metadata:    map[string]string{"status": "transcoding", "language": "es"},
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "ads/preroll/ad_480p.mp4",
+		Content:    FakeMP4(MP4Options{Duration: 5020, Width: 854, Height: 480, VideoBitrate: 800000, AudioBitrate: 96000}),
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "ads/preroll/ad_720p.mp4",
+		Content:    FakeMP4(MP4Options{Duration: 5000, Width: 1280, Height: 720, VideoBitrate: 2000000, AudioBitrate: 96000}),
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "ads/preroll/ad_en.vtt",
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "ads/postroll/bumper.mp4",
+		Content:    FakeMP4(MP4Options{Duration: 3000, Width: 1280, Height: 720, VideoBitrate: 2000000, AudioBitrate: 96000}),
+	},
+	{
+		BucketName: "my-bucket",
+		Name:       "ads/postroll/slate.mp4",
+		Metadata:   map[string]string{"duration": "2000"},
+	},
+}
+
+// WriteObjects writes the given objects to dir, using the directory layout
+// expected by the local backend (one subdirectory per bucket).
+func WriteObjects(dir string, objs []fakestorage.Object) error {
+	for _, obj := range objs {
+		p := filepath.Join(dir, obj.BucketName, filepath.FromSlash(obj.Name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(p, obj.Content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
 }
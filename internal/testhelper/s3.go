@@ -0,0 +1,93 @@
+package testhelper
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+)
+
+// NewFakeS3 starts a minimal path-style S3 server that serves the given
+// objects. It supports GetObject, HeadObject and ListObjectsV2, and rejects
+// requests that aren't signed with AWS Signature Version 4.
+func NewFakeS3(objects []fakestorage.Object) *httptest.Server {
+	modTime := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") {
+			http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+			return
+		}
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		bucket := parts[0]
+		var bucketObjects []fakestorage.Object
+		for _, obj := range objects {
+			if obj.BucketName == bucket {
+				bucketObjects = append(bucketObjects, obj)
+			}
+		}
+		if len(bucketObjects) == 0 {
+			http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+			return
+		}
+		if len(parts) == 1 || parts[1] == "" {
+			listS3Objects(w, r, bucketObjects, modTime)
+			return
+		}
+		for _, obj := range bucketObjects {
+			if obj.Name == parts[1] {
+				w.Header().Set("ETag", `"fake-etag"`)
+				http.ServeContent(w, r, obj.Name, modTime, bytes.NewReader(obj.Content))
+				return
+			}
+		}
+		http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+	}))
+}
+
+func listS3Objects(w http.ResponseWriter, r *http.Request, objects []fakestorage.Object, modTime time.Time) {
+	type content struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int64
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	var result struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		IsTruncated    bool
+		Contents       []content
+		CommonPrefixes []commonPrefix
+	}
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	seen := map[string]bool{}
+	for _, obj := range objects {
+		if !strings.HasPrefix(obj.Name, prefix) {
+			continue
+		}
+		if i := strings.Index(obj.Name[len(prefix):], delimiter); delimiter != "" && i > -1 {
+			p := obj.Name[:len(prefix)+i+len(delimiter)]
+			if !seen[p] {
+				seen[p] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: p})
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, content{
+			Key:          obj.Name,
+			LastModified: modTime,
+			ETag:         `"fake-etag"`,
+			Size:         int64(len(obj.Content)),
+		})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
@@ -12,7 +12,6 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/NYTimes/gcs-helper/v3/handlers"
 	"github.com/google/gops/agent"
-	"google.golang.org/api/option"
 )
 
 var version = "SNAPSHOT"
@@ -29,13 +28,19 @@ func main() {
 		log.Fatal(err)
 	}
 	logger := config.Logger()
-	hc, err := config.Client.HTTPClient()
-	if err != nil {
-		logger.WithError(err).Fatal("failed to initialize http client")
-	}
-	client, err := storage.NewClient(context.Background(), option.WithHTTPClient(hc))
-	if err != nil {
-		logger.WithError(err).Fatal("failed to create storage client instance")
+	var (
+		hc     *http.Client
+		client *storage.Client
+	)
+	if config.NeedsGCS() {
+		hc, err = config.Client.HTTPClient()
+		if err != nil {
+			logger.WithError(err).Fatal("failed to initialize http client")
+		}
+		client, err = config.Client.StorageClient(context.Background(), hc)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to create storage client instance")
+		}
 	}
 	handler := getHandler(config, client, hc)
 	listener, err := net.Listen("tcp", config.Listen)
@@ -10,10 +10,14 @@ import (
 
 func getHandler(c handlers.Config, client *storage.Client, hc *http.Client) http.HandlerFunc {
 	proxyHandler := handlers.Proxy(c, hc)
-	mapHandler := handlers.Map(c, client)
+	mapHandler := handlers.NewMapHandler(c, client)
+	adminHandler := mapHandler.AdminHandler()
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch {
+		case c.Map.AdminEndpoint != "" && strings.HasPrefix(r.URL.Path, c.Map.AdminEndpoint):
+			r.URL.Path = strings.Replace(r.URL.Path, c.Map.AdminEndpoint, "", 1)
+			adminHandler.ServeHTTP(w, r)
 		case strings.HasPrefix(r.URL.Path, c.Proxy.Endpoint):
 			r.URL.Path = strings.Replace(r.URL.Path, c.Proxy.Endpoint, "", 1)
 			if !strings.HasPrefix(r.URL.Path, "/") {
@@ -120,14 +120,16 @@ func TestServerMultiPrefixes(t *testing.T) {
 			Method:         http.MethodPost,
 			Addr:           addr + "/map/musics",
 			ExpectedStatus: http.StatusMethodNotAllowed,
-			ExpectedBody:   "method not allowed\n",
+			ExpectedHeader: http.Header{"Content-Type": []string{"application/json"}},
+			ExpectedBody:   map[string]interface{}{"status": float64(http.StatusMethodNotAllowed), "error": "method not allowed"},
 		},
 		{
 			TestCase:       "map: invalid url",
 			Method:         http.MethodGet,
 			Addr:           addr + "/map/",
 			ExpectedStatus: http.StatusBadRequest,
-			ExpectedBody:   "prefix cannot be empty\n",
+			ExpectedHeader: http.Header{"Content-Type": []string{"application/json"}},
+			ExpectedBody:   map[string]interface{}{"status": float64(http.StatusBadRequest), "error": "prefix cannot be empty"},
 		},
 	}
 
@@ -0,0 +1,49 @@
+package vodmodule
+
+import (
+	"context"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// Bucket is the interface used by Mapper to list and read objects. It mirrors
+// the API of *storage.BucketHandle, so storage backends other than GCS can be
+// plugged into the mapper.
+type Bucket interface {
+	Objects(ctx context.Context, q *storage.Query) ObjectIterator
+
+	// NewRangeReader reads length bytes of the given generation of the
+	// object, starting at offset. A zero generation reads the live object,
+	// and a negative length reads until the end of the object. It returns
+	// storage.ErrObjectNotExist when the object or the generation doesn't
+	// exist.
+	NewRangeReader(ctx context.Context, object string, generation, offset, length int64) (io.ReadCloser, error)
+}
+
+// ObjectIterator iterates over the results of a listing. Next returns
+// iterator.Done when there are no more objects.
+type ObjectIterator interface {
+	Next() (*storage.ObjectAttrs, error)
+}
+
+// GCSBucket adapts a *storage.BucketHandle to the Bucket interface.
+func GCSBucket(bucket *storage.BucketHandle) Bucket {
+	return gcsBucket{bucket: bucket}
+}
+
+type gcsBucket struct {
+	bucket *storage.BucketHandle
+}
+
+func (b gcsBucket) Objects(ctx context.Context, q *storage.Query) ObjectIterator {
+	return b.bucket.Objects(ctx, q)
+}
+
+func (b gcsBucket) NewRangeReader(ctx context.Context, object string, generation, offset, length int64) (io.ReadCloser, error) {
+	obj := b.bucket.Object(object)
+	if generation > 0 {
+		obj = obj.Generation(generation)
+	}
+	return obj.NewRangeReader(ctx, offset, length)
+}
@@ -0,0 +1,236 @@
+package vodmodule
+
+import (
+	"container/list"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+const defaultMappingCacheSize = 1000
+
+// MappingCache is an in-memory LRU cache of mappings.
+//
+// Mappings with sequences are kept for TTL. Mappings without sequences and
+// "not found" errors (missing buckets, objects or parts) are kept for
+// NegativeTTL, as they usually mean that the content is still being ingested.
+// Other errors are never cached.
+type MappingCache struct {
+	maxEntries  int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+// MappingCacheKey identifies a cached mapping. Bucket and Prefixes are used
+// for invalidation, while Variant distinguishes mappings of the same
+// prefixes (for example, with different filters).
+type MappingCacheKey struct {
+	Bucket   string
+	Prefixes []string
+	Variant  string
+}
+
+func (k MappingCacheKey) String() string {
+	return k.Bucket + "\x00" + strings.Join(k.Prefixes, "\x00") + "\x00" + k.Variant
+}
+
+type mappingCacheEntry struct {
+	key     MappingCacheKey
+	id      string
+	mapping Mapping
+	err     error
+	expires time.Time
+}
+
+// NewMappingCache returns a cache with up to maxEntries mappings (1000 when
+// zero or negative). A non-positive negativeTTL disables negative caching.
+func NewMappingCache(maxEntries int, ttl, negativeTTL time.Duration) *MappingCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultMappingCacheSize
+	}
+	return &MappingCache{
+		maxEntries:  maxEntries,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		lru:         list.New(),
+		entries:     map[string]*list.Element{},
+	}
+}
+
+// Get returns the cached mapping for the key, calling fn on cache misses.
+// Callers get their own copy of cached mappings, so they can modify it.
+func (c *MappingCache) Get(key MappingCacheKey, fn func() (Mapping, error)) (Mapping, error) {
+	id := key.String()
+	c.mu.Lock()
+	if elem, ok := c.entries[id]; ok {
+		entry := elem.Value.(*mappingCacheEntry)
+		if c.clock().Before(entry.expires) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return entry.mapping.Clone(), entry.err
+		}
+		c.remove(elem)
+	}
+	c.mu.Unlock()
+
+	m, err := fn()
+	ttl := c.ttl
+	if err != nil || len(m.Sequences) == 0 {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 || (err != nil && !isNotFound(err)) {
+		return m, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[id]; ok {
+		c.remove(elem)
+	}
+	entry := &mappingCacheEntry{key: key, id: id, mapping: m.Clone(), err: err, expires: c.clock().Add(ttl)}
+	c.entries[id] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+	return m, err
+}
+
+// Invalidate removes the mappings of the bucket that include objects under
+// prefix: mappings of prefix itself, of prefixes within it, and of parent
+// prefixes (which may be recursive). It returns the number of removed
+// mappings. An empty prefix removes all mappings of the bucket.
+func (c *MappingCache) Invalidate(bucket, prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var removed int
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		entry := elem.Value.(*mappingCacheEntry)
+		if entry.key.Bucket == bucket && overlaps(entry.key.Prefixes, prefix) {
+			c.remove(elem)
+			removed++
+		}
+		elem = next
+	}
+	return removed
+}
+
+// Len returns the number of cached mappings, including expired ones that
+// weren't evicted yet.
+func (c *MappingCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *MappingCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*mappingCacheEntry).id)
+}
+
+func (c *MappingCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+func overlaps(prefixes []string, prefix string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(p, prefix) || strings.HasPrefix(prefix, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, storage.ErrBucketNotExist) ||
+		errors.Is(err, storage.ErrObjectNotExist) ||
+		errors.Is(err, ErrEmptyPart)
+}
+
+// Clone returns a deep copy of the mapping. The media info of sequences is
+// shared, as it's never modified.
+func (m Mapping) Clone() Mapping {
+	clone := m
+	clone.Discontinuity = cloneBool(m.Discontinuity)
+	clone.ConsistentSequenceMediaInfo = cloneBool(m.ConsistentSequenceMediaInfo)
+	clone.Durations = cloneInt64s(m.Durations)
+	clone.ClipTimes = cloneInt64s(m.ClipTimes)
+	if m.Notifications != nil {
+		clone.Notifications = append([]Notification(nil), m.Notifications...)
+	}
+	if m.Sequences != nil {
+		clone.Sequences = make([]Sequence, len(m.Sequences))
+		for i, seq := range m.Sequences {
+			clone.Sequences[i] = seq.clone()
+		}
+	}
+	return clone
+}
+
+func (s Sequence) clone() Sequence {
+	clone := s
+	if s.Bitrate != nil {
+		bitrate := *s.Bitrate
+		clone.Bitrate = &bitrate
+	}
+	if s.AvgBitrate != nil {
+		bitrate := *s.AvgBitrate
+		clone.AvgBitrate = &bitrate
+	}
+	clone.Clips = cloneClips(s.Clips)
+	return clone
+}
+
+func (c Clip) clone() Clip {
+	clone := c
+	if c.Source != nil {
+		source := c.Source.clone()
+		clone.Source = &source
+	}
+	clone.Sources = cloneClips(c.Sources)
+	if c.Paths != nil {
+		clone.Paths = append([]string(nil), c.Paths...)
+	}
+	if c.ClipIDs != nil {
+		clone.ClipIDs = append([]string(nil), c.ClipIDs...)
+	}
+	clone.Durations = cloneInt64s(c.Durations)
+	return clone
+}
+
+func cloneClips(clips []Clip) []Clip {
+	if clips == nil {
+		return nil
+	}
+	clone := make([]Clip, len(clips))
+	for i, clip := range clips {
+		clone[i] = clip.clone()
+	}
+	return clone
+}
+
+func cloneInt64s(values []int64) []int64 {
+	if values == nil {
+		return nil
+	}
+	return append([]int64(nil), values...)
+}
+
+func cloneBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	v := *b
+	return &v
+}
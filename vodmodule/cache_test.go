@@ -0,0 +1,159 @@
+package vodmodule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func testMapping(path string) Mapping {
+	return Mapping{Sequences: []Sequence{{Clips: []Clip{{Type: ClipTypeSource, Path: path}}}}}
+}
+
+func TestMappingCache(t *testing.T) {
+	now := time.Now()
+	cache := NewMappingCache(0, time.Minute, 10*time.Second)
+	cache.now = func() time.Time { return now }
+	key := MappingCacheKey{Bucket: "my-bucket", Prefixes: []string{"videos/video1/"}}
+
+	var calls int
+	fn := func() (Mapping, error) {
+		calls++
+		return testMapping(fmt.Sprintf("/my-bucket/videos/video1/%d.mp4", calls)), nil
+	}
+	m, _ := cache.Get(key, fn)
+	m.Sequences[0].Clips[0].ClipFrom = 1000
+	m, _ = cache.Get(key, fn)
+	if calls != 1 {
+		t.Errorf("wrong number of calls\nwant 1\ngot  %d", calls)
+	}
+	if expected := testMapping("/my-bucket/videos/video1/1.mp4"); !reflect.DeepEqual(m, expected) {
+		t.Errorf("wrong cached mapping\nwant %#v\ngot  %#v", expected, m)
+	}
+
+	other := key
+	other.Variant = "mobile"
+	cache.Get(other, fn)
+	if calls != 2 {
+		t.Errorf("variant should not share the cached mapping")
+	}
+
+	now = now.Add(time.Minute)
+	m, _ = cache.Get(key, fn)
+	if expected := testMapping("/my-bucket/videos/video1/3.mp4"); !reflect.DeepEqual(m, expected) {
+		t.Errorf("expired mapping not refreshed\nwant %#v\ngot  %#v", expected, m)
+	}
+}
+
+func TestMappingCacheNegative(t *testing.T) {
+	now := time.Now()
+	cache := NewMappingCache(0, time.Minute, 10*time.Second)
+	cache.now = func() time.Time { return now }
+
+	tests := []struct {
+		name   string
+		result Mapping
+		err    error
+		cached bool
+	}{
+		{"empty mapping", Mapping{Sequences: []Sequence{}}, nil, true},
+		{"missing bucket", Mapping{}, storage.ErrBucketNotExist, true},
+		{"missing part", Mapping{}, fmt.Errorf("vodmodule: part %q: %w", "ads/", ErrEmptyPart), true},
+		{"transient error", Mapping{}, errors.New("connection reset"), false},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			key := MappingCacheKey{Bucket: "my-bucket", Prefixes: []string{test.name}}
+			var calls int
+			fn := func() (Mapping, error) {
+				calls++
+				return test.result, test.err
+			}
+			cache.Get(key, fn)
+			_, err := cache.Get(key, fn)
+			if err != test.err {
+				t.Errorf("wrong error returned\nwant %v\ngot  %v", test.err, err)
+			}
+			expectedCalls := 2
+			if test.cached {
+				expectedCalls = 1
+			}
+			if calls != expectedCalls {
+				t.Errorf("wrong number of calls\nwant %d\ngot  %d", expectedCalls, calls)
+			}
+			now = now.Add(10 * time.Second)
+			cache.Get(key, fn)
+			if calls != expectedCalls+1 {
+				t.Errorf("negative entry should expire after the negative TTL")
+			}
+		})
+	}
+}
+
+func TestMappingCacheEviction(t *testing.T) {
+	cache := NewMappingCache(2, time.Minute, 0)
+	fn := func() (Mapping, error) { return testMapping("/my-bucket/video.mp4"), nil }
+	for _, prefix := range []string{"a/", "b/", "a/", "c/"} {
+		cache.Get(MappingCacheKey{Bucket: "my-bucket", Prefixes: []string{prefix}}, fn)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("wrong number of entries\nwant 2\ngot  %d", cache.Len())
+	}
+	var calls int
+	cache.Get(MappingCacheKey{Bucket: "my-bucket", Prefixes: []string{"a/"}}, func() (Mapping, error) {
+		calls++
+		return fn()
+	})
+	if calls != 0 {
+		t.Error("recently used entry was evicted")
+	}
+}
+
+func TestMappingCacheInvalidate(t *testing.T) {
+	cache := NewMappingCache(0, time.Minute, 0)
+	fn := func() (Mapping, error) { return testMapping("/my-bucket/video.mp4"), nil }
+	keys := []MappingCacheKey{
+		{Bucket: "my-bucket", Prefixes: []string{"videos/"}},
+		{Bucket: "my-bucket", Prefixes: []string{"videos/video1/"}},
+		{Bucket: "my-bucket", Prefixes: []string{"videos/video1/"}, Variant: "mobile"},
+		{Bucket: "my-bucket", Prefixes: []string{"videos/video1/clip1_"}},
+		{Bucket: "my-bucket", Prefixes: []string{"ads/preroll/", "videos/video1/"}, Variant: "parts"},
+		{Bucket: "my-bucket", Prefixes: []string{"videos/video2/"}},
+		{Bucket: "other-bucket", Prefixes: []string{"videos/video1/"}},
+	}
+	for _, key := range keys {
+		cache.Get(key, fn)
+	}
+	if n := cache.Invalidate("my-bucket", "videos/video1/"); n != 5 {
+		t.Errorf("wrong number of invalidated entries\nwant 5\ngot  %d", n)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("wrong number of remaining entries\nwant 2\ngot  %d", cache.Len())
+	}
+}
+
+func TestMappingClone(t *testing.T) {
+	for _, example := range mappingExamples {
+		example := example
+		t.Run(example.name, func(t *testing.T) {
+			var m Mapping
+			if err := json.Unmarshal([]byte(example.json), &m); err != nil {
+				t.Fatal(err)
+			}
+			clone := m.Clone()
+			if !reflect.DeepEqual(clone, m) {
+				t.Fatalf("clone differs from the original\nwant %#v\ngot  %#v", m, clone)
+			}
+			clone.Sequences[0].Clips[0].Type = "modified"
+			if m.Sequences[0].Clips[0].Type == "modified" {
+				t.Error("clone shares clips with the original")
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package vodmodule
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule/mp4"
+)
+
+// DASH profile of the MPDs written by WriteDASH.
+const dashOnDemandProfile = "urn:mpeg:dash:profile:isoff-on-demand:2011"
+
+// captionMimeTypes maps the extensions of captions to the mime types used in
+// DASH.
+var captionMimeTypes = map[string]string{
+	".dfxp": "application/ttml+xml",
+	".srt":  "application/x-subrip",
+	".ttml": "application/ttml+xml",
+	".vtt":  "text/vtt",
+}
+
+type dashMPD struct {
+	XMLName                   xml.Name   `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	Profiles                  string     `xml:"profiles,attr"`
+	Type                      string     `xml:"type,attr"`
+	MinBufferTime             string     `xml:"minBufferTime,attr"`
+	MediaPresentationDuration string     `xml:"mediaPresentationDuration,attr,omitempty"`
+	Period                    dashPeriod `xml:"Period"`
+}
+
+type dashPeriod struct {
+	ID             string              `xml:"id,attr"`
+	AdaptationSets []dashAdaptationSet `xml:"AdaptationSet"`
+}
+
+type dashAdaptationSet struct {
+	ID                      int                  `xml:"id,attr"`
+	ContentType             string               `xml:"contentType,attr"`
+	MimeType                string               `xml:"mimeType,attr"`
+	Lang                    string               `xml:"lang,attr,omitempty"`
+	SubsegmentAlignment     bool                 `xml:"subsegmentAlignment,attr,omitempty"`
+	SubsegmentStartsWithSAP int                  `xml:"subsegmentStartsWithSAP,attr,omitempty"`
+	Role                    *dashRole            `xml:"Role"`
+	Label                   string               `xml:"Label,omitempty"`
+	Representations         []dashRepresentation `xml:"Representation"`
+}
+
+type dashRole struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type dashRepresentation struct {
+	ID          string           `xml:"id,attr"`
+	Bandwidth   int64            `xml:"bandwidth,attr"`
+	Codecs      string           `xml:"codecs,attr,omitempty"`
+	Width       int              `xml:"width,attr,omitempty"`
+	Height      int              `xml:"height,attr,omitempty"`
+	BaseURL     string           `xml:"BaseURL"`
+	SegmentBase *dashSegmentBase `xml:"SegmentBase"`
+}
+
+type dashSegmentBase struct {
+	IndexRange     string             `xml:"indexRange,attr"`
+	Initialization dashInitialization `xml:"Initialization"`
+}
+
+type dashInitialization struct {
+	Range string `xml:"range,attr"`
+}
+
+// WriteDASH writes the mapping as a static DASH MPD, in the on-demand profile,
+// where each representation refers to a whole file through its clip path.
+// Video sequences are grouped in a single adaptation set, while audio and
+// captions are grouped by language.
+//
+// Byte ranges of the initialization segment and of the segment index come
+// from probing, and are only known for fragmented files with a "sidx" box,
+// which the on-demand profile requires. Mappings of live playlists, sequences
+// with multiple clips and audio or video sequences without a bitrate or a
+// segment index are rejected with ErrUnsupportedMapping.
+func (m *Mapping) WriteDASH(w io.Writer) error {
+	if m.PlaylistType == PlaylistTypeLive {
+		return fmt.Errorf("%w: live playlists can't be written as DASH MPDs", ErrUnsupportedMapping)
+	}
+	var (
+		sets     []dashAdaptationSet
+		byKey    = map[string]int{}
+		duration int64
+	)
+	for i, seq := range m.Sequences {
+		if len(seq.Clips) != 1 || sequencePath(seq) == "" {
+			return fmt.Errorf("%w: sequence %d must have a single source clip", ErrUnsupportedMapping, i)
+		}
+		kind := sequenceKind(seq)
+		peak, _ := sequenceBandwidth(seq)
+		if peak == 0 && kind != mp4.KindText {
+			return fmt.Errorf("%w: unknown bitrate of %s", ErrUnsupportedMapping, seq.Clips[0].Path)
+		}
+		if kind != mp4.KindText && (seq.Media == nil || seq.Media.Index == nil) {
+			return fmt.Errorf("%w: unknown segment index of %s", ErrUnsupportedMapping, seq.Clips[0].Path)
+		}
+		rep := dashRepresentation{
+			ID:        seq.ID,
+			Bandwidth: peak,
+			BaseURL:   seq.Clips[0].Path,
+		}
+		if rep.ID == "" {
+			rep.ID = strconv.Itoa(i + 1)
+		}
+		if media := seq.Media; media != nil {
+			rep.Codecs = strings.Join(media.Codecs(), ",")
+			if media.Index != nil {
+				rep.SegmentBase = &dashSegmentBase{
+					IndexRange:     media.Index.String(),
+					Initialization: dashInitialization{Range: media.Init.String()},
+				}
+			}
+			if ms := media.DurationMillis(); ms > duration {
+				duration = ms
+			}
+		}
+		if kind == mp4.KindVideo {
+			rep.Width, rep.Height = seq.Width, seq.Height
+		}
+
+		key := kind
+		if kind != mp4.KindVideo {
+			key += "/" + seq.Language
+		}
+		idx, ok := byKey[key]
+		if !ok {
+			idx = len(sets)
+			byKey[key] = idx
+			sets = append(sets, newDASHAdaptationSet(idx, kind, seq))
+		}
+		sets[idx].Representations = append(sets[idx].Representations, rep)
+	}
+
+	mpd := dashMPD{
+		Profiles:      dashOnDemandProfile,
+		Type:          "static",
+		MinBufferTime: "PT2S",
+		Period:        dashPeriod{ID: "0", AdaptationSets: sets},
+	}
+	if duration > 0 {
+		mpd.MediaPresentationDuration = isoDuration(duration)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(mpd); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func newDASHAdaptationSet(id int, kind string, seq Sequence) dashAdaptationSet {
+	set := dashAdaptationSet{ID: id, ContentType: kind}
+	switch kind {
+	case mp4.KindText:
+		set.MimeType = captionMimeTypes[strings.ToLower(path.Ext(sequencePath(seq)))]
+		set.Role = &dashRole{SchemeIDURI: "urn:mpeg:dash:role:2011", Value: "subtitle"}
+	default:
+		set.MimeType = kind + "/mp4"
+		set.SubsegmentAlignment = true
+		set.SubsegmentStartsWithSAP = 1
+	}
+	if kind != mp4.KindVideo {
+		if seq.Language != "" {
+			set.Lang = languageTag(seq.Language)
+		}
+		set.Label = seq.Label
+	}
+	return set
+}
+
+// sequenceBandwidth returns the peak and the average bitrates of the
+// sequence, in bits per second. The peak bitrate defaults to the average one.
+func sequenceBandwidth(seq Sequence) (peak, average int64) {
+	if bitrate := seq.Bitrate; bitrate != nil {
+		peak = bitrate.Video + bitrate.Audio
+	}
+	if bitrate := seq.AvgBitrate; bitrate != nil {
+		average = bitrate.Video + bitrate.Audio
+	}
+	if peak == 0 {
+		peak = average
+	}
+	return peak, average
+}
+
+// isoDuration formats the duration in milliseconds as an ISO 8601 duration,
+// in seconds.
+func isoDuration(ms int64) string {
+	return "PT" + strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64) + "S"
+}
@@ -0,0 +1,105 @@
+package vodmodule
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule/mp4"
+)
+
+func TestWriteDASH(t *testing.T) {
+	video := sourceSequence("/my-bucket/videos/video1_720p.mp4?generation=1")
+	video.ID, video.Width, video.Height = "720p", 1280, 720
+	video.AvgBitrate = &Bitrate{Video: 2500000}
+	video.Media = &mp4.Info{
+		Timescale: 1000,
+		Duration:  10500,
+		Tracks:    []mp4.Track{{Kind: mp4.KindVideo, Codec: "avc1.64001f", Width: 1280, Height: 720}},
+		Init:      mp4.Range{First: 0, Last: 765},
+		Index:     &mp4.Range{First: 766, Last: 805},
+	}
+	audio := sourceSequence("/my-bucket/videos/video1_audio_es.m4a")
+	audio.Language, audio.Label = "spa", "Spanish"
+	audio.Bitrate = &Bitrate{Audio: 128000}
+	audio.Media = &mp4.Info{
+		Timescale: 1000,
+		Duration:  10000,
+		Tracks:    []mp4.Track{{Kind: mp4.KindAudio, Codec: "mp4a.40.2", Language: "spa"}},
+		Init:      mp4.Range{First: 0, Last: 612},
+		Index:     &mp4.Range{First: 613, Last: 652},
+	}
+	captions := sourceSequence("/my-bucket/videos/video1_en.vtt")
+	captions.Language, captions.Label = "eng", "English"
+
+	m := Mapping{Sequences: []Sequence{video, audio, captions}}
+	var buf bytes.Buffer
+	if err := m.WriteDASH(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-on-demand:2011" type="static" minBufferTime="PT2S" mediaPresentationDuration="PT10.5S">
+  <Period id="0">
+    <AdaptationSet id="0" contentType="video" mimeType="video/mp4" subsegmentAlignment="true" subsegmentStartsWithSAP="1">
+      <Representation id="720p" bandwidth="2500000" codecs="avc1.64001f" width="1280" height="720">
+        <BaseURL>/my-bucket/videos/video1_720p.mp4?generation=1</BaseURL>
+        <SegmentBase indexRange="766-805">
+          <Initialization range="0-765"></Initialization>
+        </SegmentBase>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet id="1" contentType="audio" mimeType="audio/mp4" lang="es" subsegmentAlignment="true" subsegmentStartsWithSAP="1">
+      <Label>Spanish</Label>
+      <Representation id="2" bandwidth="128000" codecs="mp4a.40.2">
+        <BaseURL>/my-bucket/videos/video1_audio_es.m4a</BaseURL>
+        <SegmentBase indexRange="613-652">
+          <Initialization range="0-612"></Initialization>
+        </SegmentBase>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet id="2" contentType="text" mimeType="text/vtt" lang="en">
+      <Role schemeIdUri="urn:mpeg:dash:role:2011" value="subtitle"></Role>
+      <Label>English</Label>
+      <Representation id="3" bandwidth="0">
+        <BaseURL>/my-bucket/videos/video1_en.vtt</BaseURL>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("wrong MPD\nwant:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestWriteDASHUnsupported(t *testing.T) {
+	withBitrate := sourceSequence("/my-bucket/video1_720p.mp4")
+	withBitrate.Bitrate = &Bitrate{Video: 2500000}
+	withoutIndex := withBitrate
+	withoutIndex.Media = &mp4.Info{Tracks: []mp4.Track{{Kind: mp4.KindVideo, Codec: "avc1.64001f"}}}
+	tests := []struct {
+		name    string
+		mapping Mapping
+	}{
+		{"live", Mapping{PlaylistType: PlaylistTypeLive, Sequences: []Sequence{withBitrate}}},
+		{"unknown bitrate", Mapping{Sequences: []Sequence{sourceSequence("/my-bucket/video1_720p.mp4")}}},
+		{"not probed", Mapping{Sequences: []Sequence{withBitrate}}},
+		{"no segment index", Mapping{Sequences: []Sequence{withoutIndex}}},
+		{
+			"multiple clips",
+			Mapping{Sequences: []Sequence{{
+				Bitrate: withBitrate.Bitrate,
+				Clips:   []Clip{withBitrate.Clips[0], withBitrate.Clips[0]},
+			}}},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := test.mapping.WriteDASH(&bytes.Buffer{})
+			if !errors.Is(err, ErrUnsupportedMapping) {
+				t.Errorf("wrong error returned\nwant %v\ngot  %v", ErrUnsupportedMapping, err)
+			}
+		})
+	}
+}
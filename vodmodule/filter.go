@@ -0,0 +1,107 @@
+package vodmodule
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// MetadataFilter is a predicate on the custom metadata of objects (the
+// "x-goog-meta-*" headers in GCS). Keys are case-insensitive.
+//
+// When Exists is set, the filter only requires the key to exist. Otherwise,
+// the value must match Pattern, when set, or be equal to Value, which may be
+// empty.
+type MetadataFilter struct {
+	Key     string
+	Value   string
+	Pattern *regexp.Regexp
+	Exists  bool
+}
+
+// ParseMetadataFilter parses filters in the formats "key=value" (equals),
+// "key~regexp" (matches) and "key" (exists). "key=" matches an empty value.
+// The "x-goog-meta-" prefix is optional.
+func ParseMetadataFilter(value string) (MetadataFilter, error) {
+	var f MetadataFilter
+	i := strings.IndexAny(value, "=~")
+	if i < 0 {
+		f.Key, f.Exists = value, true
+	} else {
+		f.Key = value[:i]
+		if value[i] == '=' {
+			f.Value = value[i+1:]
+		} else {
+			pattern, err := regexp.Compile(value[i+1:])
+			if err != nil {
+				return f, fmt.Errorf("invalid metadata filter %q: %v", value, err)
+			}
+			f.Pattern = pattern
+		}
+	}
+	f.Key = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(f.Key)), "x-goog-meta-")
+	if f.Key == "" {
+		return f, fmt.Errorf("invalid metadata filter %q: missing key", value)
+	}
+	return f, nil
+}
+
+// Match reports whether the metadata satisfies the filter.
+func (f MetadataFilter) Match(metadata map[string]string) bool {
+	for key, value := range metadata {
+		if !strings.EqualFold(key, f.Key) {
+			continue
+		}
+		switch {
+		case f.Exists:
+			return true
+		case f.Pattern != nil:
+			return f.Pattern.MatchString(value)
+		default:
+			return value == f.Value
+		}
+	}
+	return false
+}
+
+// matchContentType reports whether the content type matches one of the
+// given types. Types may use wildcards for the subtype, as in "video/*".
+// Parameters (like codecs) are ignored.
+func matchContentType(contentType string, types []string) bool {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	for _, typ := range types {
+		typ = strings.ToLower(typ)
+		if typ == contentType {
+			return true
+		}
+		if strings.HasSuffix(typ, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(typ, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether the object passes the filters in the options.
+func (o MapOptions) match(obj *storage.ObjectAttrs) bool {
+	if o.Filter != nil && !o.Filter.MatchString(path.Base(obj.Name)) {
+		return false
+	}
+	if o.Exclude != nil && o.Exclude.MatchString(path.Base(obj.Name)) {
+		return false
+	}
+	if len(o.ContentTypes) > 0 && !matchContentType(obj.ContentType, o.ContentTypes) {
+		return false
+	}
+	for _, f := range o.MetadataFilters {
+		if !f.Match(obj.Metadata) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package vodmodule
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestParseMetadataFilter(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected MetadataFilter
+	}{
+		{"status=ready", MetadataFilter{Key: "status", Value: "ready"}},
+		{"x-goog-meta-Status=ready", MetadataFilter{Key: "status", Value: "ready"}},
+		{"language~^(es|en)$", MetadataFilter{Key: "language", Pattern: regexp.MustCompile(`^(es|en)$`)}},
+		{"reviewed", MetadataFilter{Key: "reviewed", Exists: true}},
+		{"notes=", MetadataFilter{Key: "notes"}},
+		{"expr=a~b", MetadataFilter{Key: "expr", Value: "a~b"}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.input, func(t *testing.T) {
+			f, err := ParseMetadataFilter(test.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Key != test.expected.Key || f.Value != test.expected.Value || f.Exists != test.expected.Exists || patternString(f.Pattern) != patternString(test.expected.Pattern) {
+				t.Errorf("wrong filter\nwant %#v\ngot  %#v", test.expected, f)
+			}
+		})
+	}
+	for _, input := range []string{"", "=ready", "status~(", "x-goog-meta-"} {
+		if _, err := ParseMetadataFilter(input); err == nil {
+			t.Errorf("%q: unexpected <nil> error", input)
+		}
+	}
+}
+
+func patternString(re *regexp.Regexp) string {
+	if re == nil {
+		return ""
+	}
+	return re.String()
+}
+
+func TestMetadataFilterMatch(t *testing.T) {
+	tests := []struct {
+		filter   string
+		metadata map[string]string
+		expected bool
+	}{
+		{"status=ready", map[string]string{"Status": "ready"}, true},
+		{"status=ready", map[string]string{"status": "transcoding"}, false},
+		{"notes=", map[string]string{"notes": ""}, true},
+		{"notes=", map[string]string{"notes": "draft"}, false},
+		{"notes=", nil, false},
+		{"reviewed", map[string]string{"reviewed": ""}, true},
+		{"reviewed", map[string]string{"reviewed": "yes"}, true},
+		{"reviewed", nil, false},
+		{"language~^(es|en)$", map[string]string{"language": "es"}, true},
+	}
+	for _, test := range tests {
+		f, err := ParseMetadataFilter(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Match(test.metadata); got != test.expected {
+			t.Errorf("%q.Match(%v): want %v, got %v", test.filter, test.metadata, test.expected, got)
+		}
+	}
+}
+
+func TestMatchContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		types       []string
+		expected    bool
+	}{
+		{"video/mp4", []string{"video/mp4"}, true},
+		{"Video/MP4; codecs=avc1", []string{"video/mp4"}, true},
+		{"video/mp4", []string{"audio/*", "video/*"}, true},
+		{"video/mp4", []string{"audio/*"}, false},
+		{"videos/mp4", []string{"video/*"}, false},
+		{"", []string{"video/*"}, false},
+	}
+	for _, test := range tests {
+		if got := matchContentType(test.contentType, test.types); got != test.expected {
+			t.Errorf("matchContentType(%q, %v): want %v, got %v", test.contentType, test.types, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package vodmodule
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule/mp4"
+)
+
+// DefaultHLSVariantURL is the template used for the URLs of media playlists
+// when none is given, which matches the URLs of nginx-vod-module in the
+// local and remote modes when it's served from the same location.
+const DefaultHLSVariantURL = "{path}/index.m3u8"
+
+// Group ids of the renditions in HLS master playlists.
+const (
+	hlsAudioGroup     = "audio"
+	hlsSubtitlesGroup = "subs"
+)
+
+// ErrUnsupportedMapping is returned when a mapping can't be represented in
+// the requested output format.
+var ErrUnsupportedMapping = errors.New("vodmodule: unsupported mapping")
+
+// HLSOptions configures the HLS master playlists written by WriteHLS.
+type HLSOptions struct {
+	// VariantURL is the template of the URLs of media playlists, with the
+	// following placeholders:
+	//
+	//   - {path}: the path of the clip, without the query string
+	//   - {id}: the id of the sequence
+	//   - {index}: the position of the sequence in the mapping, starting at
+	//     1
+	//
+	// Defaults to DefaultHLSVariantURL.
+	VariantURL string
+}
+
+// Validate checks that VariantURL only uses known placeholders.
+func (o HLSOptions) Validate() error {
+	for _, placeholder := range placeholderRegexp.FindAllString(o.VariantURL, -1) {
+		switch placeholder {
+		case "{path}", "{id}", "{index}":
+		default:
+			return fmt.Errorf("invalid variant URL template %q: unknown placeholder %s", o.VariantURL, placeholder)
+		}
+	}
+	return nil
+}
+
+func (o HLSOptions) url(seq Sequence, index int) string {
+	template := o.VariantURL
+	if template == "" {
+		template = DefaultHLSVariantURL
+	}
+	return placeholderRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		switch placeholder {
+		case "{path}":
+			return sequencePath(seq)
+		case "{id}":
+			return seq.ID
+		case "{index}":
+			return strconv.Itoa(index + 1)
+		}
+		return placeholder
+	})
+}
+
+// hlsRendition is a sequence along with the attributes used in the master
+// playlist.
+type hlsRendition struct {
+	seq       Sequence
+	url       string
+	bandwidth int64
+	average   int64
+	codecs    []string
+}
+
+// WriteHLS writes the mapping as an HLS master playlist. Video sequences are
+// listed as variants, audio-only sequences as alternative renditions (or as
+// variants when there's no video) and captions as subtitles.
+//
+// Variants need a bandwidth, which comes from the bitrates captured by the
+// naming convention or probed. Mappings of live playlists, sequences with
+// multiple clips (when VariantURL uses {path}) and sequences without a
+// bitrate are rejected with ErrUnsupportedMapping.
+func (m *Mapping) WriteHLS(w io.Writer, opts HLSOptions) error {
+	if m.PlaylistType == PlaylistTypeLive {
+		return fmt.Errorf("%w: live playlists can't be written as HLS master playlists", ErrUnsupportedMapping)
+	}
+	usesPath := opts.VariantURL == "" || strings.Contains(opts.VariantURL, "{path}")
+	var videos, audios, subtitles []hlsRendition
+	for i, seq := range m.Sequences {
+		if usesPath && (len(seq.Clips) != 1 || sequencePath(seq) == "") {
+			return fmt.Errorf("%w: sequence %d must have a single source clip", ErrUnsupportedMapping, i)
+		}
+		r := hlsRendition{seq: seq, url: opts.url(seq, i)}
+		if seq.Media != nil {
+			r.codecs = seq.Media.Codecs()
+		}
+		r.bandwidth, r.average = sequenceBandwidth(seq)
+		switch sequenceKind(seq) {
+		case mp4.KindText:
+			subtitles = append(subtitles, r)
+		case mp4.KindAudio:
+			audios = append(audios, r)
+		default:
+			videos = append(videos, r)
+		}
+	}
+
+	variants, audioGroup := videos, audios
+	if len(videos) == 0 {
+		variants, audioGroup = audios, nil
+	}
+	if len(variants) == 0 {
+		return fmt.Errorf("%w: no audio or video sequences", ErrUnsupportedMapping)
+	}
+	for _, renditions := range [][]hlsRendition{variants, audioGroup} {
+		for _, r := range renditions {
+			if r.bandwidth == 0 {
+				return fmt.Errorf("%w: unknown bitrate of %s", ErrUnsupportedMapping, r.url)
+			}
+		}
+	}
+	var groupBandwidth int64
+	var groupCodecs []string
+	for _, r := range audioGroup {
+		if r.bandwidth > groupBandwidth {
+			groupBandwidth = r.bandwidth
+		}
+		groupCodecs = appendMissing(groupCodecs, r.codecs...)
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for i, r := range audioGroup {
+		writeHLSMedia(bw, "AUDIO", hlsAudioGroup, r, i == 0)
+	}
+	for _, r := range subtitles {
+		writeHLSMedia(bw, "SUBTITLES", hlsSubtitlesGroup, r, false)
+	}
+	for _, r := range variants {
+		attrs := []string{"BANDWIDTH=" + strconv.FormatInt(r.bandwidth+groupBandwidth, 10)}
+		if r.average > 0 {
+			attrs = append(attrs, "AVERAGE-BANDWIDTH="+strconv.FormatInt(r.average+groupBandwidth, 10))
+		}
+		if len(r.codecs) > 0 {
+			codecs := appendMissing(r.codecs, groupCodecs...)
+			attrs = append(attrs, "CODECS="+strconv.Quote(strings.Join(codecs, ",")))
+		}
+		if r.seq.Width > 0 && r.seq.Height > 0 {
+			attrs = append(attrs, fmt.Sprintf("RESOLUTION=%dx%d", r.seq.Width, r.seq.Height))
+		}
+		if len(audioGroup) > 0 {
+			attrs = append(attrs, "AUDIO="+strconv.Quote(hlsAudioGroup))
+		}
+		if len(subtitles) > 0 {
+			attrs = append(attrs, "SUBTITLES="+strconv.Quote(hlsSubtitlesGroup))
+		}
+		bw.WriteString("#EXT-X-STREAM-INF:" + strings.Join(attrs, ",") + "\n" + r.url + "\n")
+	}
+	return bw.Flush()
+}
+
+func writeHLSMedia(w *bufio.Writer, mediaType, group string, r hlsRendition, isDefault bool) {
+	name := r.seq.Label
+	if name == "" {
+		name = r.seq.Language
+	}
+	if name == "" {
+		name = r.seq.ID
+	}
+	if name == "" {
+		name = path.Base(sequencePath(r.seq))
+	}
+	attrs := []string{
+		"TYPE=" + mediaType,
+		"GROUP-ID=" + strconv.Quote(group),
+		"NAME=" + strconv.Quote(name),
+	}
+	if r.seq.Language != "" {
+		attrs = append(attrs, "LANGUAGE="+strconv.Quote(languageTag(r.seq.Language)))
+	}
+	if isDefault {
+		attrs = append(attrs, "DEFAULT=YES")
+	} else {
+		attrs = append(attrs, "DEFAULT=NO")
+	}
+	attrs = append(attrs, "AUTOSELECT=YES", "URI="+strconv.Quote(r.url))
+	w.WriteString("#EXT-X-MEDIA:" + strings.Join(attrs, ",") + "\n")
+}
+
+// sequencePath returns the path of the first clip of the sequence without the
+// query string, when it's a source clip.
+func sequencePath(seq Sequence) string {
+	if len(seq.Clips) == 0 || seq.Clips[0].Type != ClipTypeSource {
+		return ""
+	}
+	return strings.SplitN(seq.Clips[0].Path, "?", 2)[0]
+}
+
+// sequenceKind returns the kind of media in the sequence: mp4.KindText for
+// captions, mp4.KindAudio for audio-only sequences and mp4.KindVideo
+// otherwise. Probed sequences are classified by their tracks, other ones by
+// the extension of the first clip.
+func sequenceKind(seq Sequence) string {
+	ext := strings.ToLower(path.Ext(sequencePath(seq)))
+	switch {
+	case captionExtensions[ext]:
+		return mp4.KindText
+	case seq.Media != nil && seq.Media.Track(mp4.KindVideo) == nil && seq.Media.Track(mp4.KindAudio) != nil:
+		return mp4.KindAudio
+	case seq.Media == nil && audioExtensions[ext]:
+		return mp4.KindAudio
+	}
+	return mp4.KindVideo
+}
+
+// languageTag converts an ISO-639-2 code into the language tag preferred by
+// RFC 5646, which uses ISO-639-1 codes when available.
+func languageTag(code string) string {
+	if short, ok := shortLanguageCodes[code]; ok {
+		return short
+	}
+	return code
+}
+
+func appendMissing(values []string, candidates ...string) []string {
+	result := append([]string(nil), values...)
+	for _, candidate := range candidates {
+		found := false
+		for _, value := range result {
+			if value == candidate {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, candidate)
+		}
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+package vodmodule
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule/mp4"
+)
+
+func sourceSequence(path string) Sequence {
+	return Sequence{Clips: []Clip{{Type: ClipTypeSource, Path: path}}}
+}
+
+func TestWriteHLS(t *testing.T) {
+	video := func(path string, width, height int, bitrate int64) Sequence {
+		seq := sourceSequence(path)
+		seq.Width, seq.Height = width, height
+		seq.AvgBitrate = &Bitrate{Video: bitrate}
+		seq.Media = &mp4.Info{Tracks: []mp4.Track{{Kind: mp4.KindVideo, Codec: "avc1.64001f", Width: width, Height: height}}}
+		return seq
+	}
+	audio := sourceSequence("/my-bucket/videos/video1_audio_es.m4a")
+	audio.Language, audio.Label = "spa", "Spanish"
+	audio.Bitrate = &Bitrate{Audio: 128000}
+	audio.Media = &mp4.Info{Tracks: []mp4.Track{{Kind: mp4.KindAudio, Codec: "mp4a.40.2", Language: "spa"}}}
+	captions := sourceSequence("/my-bucket/videos/video1_en.vtt")
+	captions.Language, captions.Label = "eng", "English"
+
+	tests := []struct {
+		name     string
+		mapping  Mapping
+		opts     HLSOptions
+		expected string
+	}{
+		{
+			"video, audio and captions",
+			Mapping{Sequences: []Sequence{
+				video("/my-bucket/videos/video1_720p.mp4?generation=1", 1280, 720, 2500000),
+				video("/my-bucket/videos/video1_480p.mp4", 854, 480, 1000000),
+				audio,
+				captions,
+			}},
+			HLSOptions{},
+			`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="Spanish",LANGUAGE="es",DEFAULT=YES,AUTOSELECT=YES,URI="/my-bucket/videos/video1_audio_es.m4a/index.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",LANGUAGE="en",DEFAULT=NO,AUTOSELECT=YES,URI="/my-bucket/videos/video1_en.vtt/index.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=2628000,AVERAGE-BANDWIDTH=2628000,CODECS="avc1.64001f,mp4a.40.2",RESOLUTION=1280x720,AUDIO="audio",SUBTITLES="subs"
+/my-bucket/videos/video1_720p.mp4/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=1128000,AVERAGE-BANDWIDTH=1128000,CODECS="avc1.64001f,mp4a.40.2",RESOLUTION=854x480,AUDIO="audio",SUBTITLES="subs"
+/my-bucket/videos/video1_480p.mp4/index.m3u8
+`,
+		},
+		{
+			"bitrates from naming conventions",
+			Mapping{Sequences: []Sequence{
+				{ID: "1080p", Bitrate: &Bitrate{Video: 4500000}, Clips: []Clip{{Type: ClipTypeSource, Path: "/my-bucket/show_1080p_4500k.mp4"}}},
+				{ID: "720p", Bitrate: &Bitrate{Video: 2500000}, Clips: []Clip{{Type: ClipTypeSource, Path: "/my-bucket/show_720p_2500k.mp4"}}},
+			}},
+			HLSOptions{VariantURL: "https://cdn.example.com/hls/{id}/index.m3u8"},
+			`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-STREAM-INF:BANDWIDTH=4500000
+https://cdn.example.com/hls/1080p/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000
+https://cdn.example.com/hls/720p/index.m3u8
+`,
+		},
+		{
+			"audio only",
+			Mapping{Sequences: []Sequence{audio}},
+			HLSOptions{VariantURL: "index-f{index}.m3u8"},
+			`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-STREAM-INF:BANDWIDTH=128000,CODECS="mp4a.40.2"
+index-f1.m3u8
+`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.opts.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := test.mapping.WriteHLS(&buf, test.opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != test.expected {
+				t.Errorf("wrong playlist\nwant:\n%s\ngot:\n%s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestWriteHLSUnsupported(t *testing.T) {
+	withBitrate := sourceSequence("/my-bucket/video1_720p.mp4")
+	withBitrate.Bitrate = &Bitrate{Video: 2500000}
+	tests := []struct {
+		name    string
+		mapping Mapping
+	}{
+		{"live", Mapping{PlaylistType: PlaylistTypeLive, Sequences: []Sequence{withBitrate}}},
+		{"unknown bitrate", Mapping{Sequences: []Sequence{sourceSequence("/my-bucket/video1_720p.mp4")}}},
+		{"captions only", Mapping{Sequences: []Sequence{sourceSequence("/my-bucket/video1_en.vtt")}}},
+		{
+			"multiple clips",
+			Mapping{Sequences: []Sequence{{
+				Bitrate: withBitrate.Bitrate,
+				Clips:   []Clip{withBitrate.Clips[0], withBitrate.Clips[0]},
+			}}},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := test.mapping.WriteHLS(&bytes.Buffer{}, HLSOptions{})
+			if !errors.Is(err, ErrUnsupportedMapping) {
+				t.Errorf("wrong error returned\nwant %v\ngot  %v", ErrUnsupportedMapping, err)
+			}
+		})
+	}
+}
+
+func TestHLSOptionsValidate(t *testing.T) {
+	if err := (HLSOptions{VariantURL: "{path}/{bucket}.m3u8"}).Validate(); err == nil {
+		t.Error("unexpected <nil> error")
+	}
+}
@@ -0,0 +1,234 @@
+package vodmodule
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"cloud.google.com/go/storage"
+)
+
+// Manifest modes.
+const (
+	// ManifestMerge merges the manifest with the sequences derived from the
+	// listing. Sequences in the manifest replace the fields of listed
+	// sequences with the same id, and are appended to the mapping
+	// otherwise. Top-level fields in the manifest are copied to the mapping.
+	ManifestMerge = "merge"
+
+	// ManifestOverride returns the manifest as the mapping, ignoring the
+	// listing.
+	ManifestOverride = "override"
+)
+
+// ErrInvalidManifest is returned when the manifest stored in the prefix can't
+// be decoded, fails validation or can't be applied to the listed mapping.
+var ErrInvalidManifest = errors.New("vodmodule: invalid manifest")
+
+// Manifest is a hand-written mapping stored along with the media files, in
+// the format expected by nginx-vod-module plus the mode ("merge" by default).
+type Manifest struct {
+	Mode string `json:"mode,omitempty"`
+	Mapping
+}
+
+// LoadManifest reads and validates the manifest stored in the given object.
+// It returns nil when the object doesn't exist.
+func LoadManifest(ctx context.Context, bucket Bucket, object string) (*Manifest, error) {
+	r, err := bucket.NewRangeReader(ctx, object, 0, 0, -1)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&manifest)
+	if err == nil {
+		err = manifest.Validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrInvalidManifest, object, err)
+	}
+	return &manifest, nil
+}
+
+// Validate checks the mode of the manifest and its mapping. Manifests in the
+// merge mode may contain partial sequences, with an id and no clips.
+func (m *Manifest) Validate() error {
+	switch m.Mode {
+	case "", ManifestMerge:
+	case ManifestOverride:
+		return m.Mapping.Validate()
+	default:
+		return fmt.Errorf("invalid mode %q", m.Mode)
+	}
+	if err := validatePlaylistType(m.PlaylistType); err != nil {
+		return err
+	}
+	for i, seq := range m.Sequences {
+		if seq.ID == "" && len(seq.Clips) == 0 {
+			return fmt.Errorf("sequence %d: missing id or clips", i)
+		}
+		for j, clip := range seq.Clips {
+			if err := clip.Validate(); err != nil {
+				return fmt.Errorf("sequence %d, clip %d: %v", i, j, err)
+			}
+		}
+	}
+	return nil
+}
+
+// apply returns the mapping that results from applying the manifest to the
+// mapping derived from the listing.
+func (m *Manifest) apply(listed Mapping) (Mapping, error) {
+	if m.Mode == ManifestOverride {
+		return m.Mapping, nil
+	}
+
+	// top-level fields are copied by encoding the manifest, so unset
+	// (omitted) fields don't reset the ones in the listed mapping.
+	top := m.Mapping
+	top.Sequences = nil
+	data, err := json.Marshal(top)
+	if err != nil {
+		return listed, err
+	}
+	merged := listed
+	merged.Sequences = nil
+	if err = json.Unmarshal(data, &merged); err != nil {
+		return listed, err
+	}
+
+	merged.Sequences = append([]Sequence{}, listed.Sequences...)
+	byID := map[string]int{}
+	for i, seq := range merged.Sequences {
+		if seq.ID != "" {
+			byID[seq.ID] = i
+		}
+	}
+	for _, seq := range m.Sequences {
+		i, ok := byID[seq.ID]
+		if !ok || seq.ID == "" {
+			if len(seq.Clips) == 0 {
+				return listed, fmt.Errorf("%w: sequence %q doesn't match any sequence", ErrInvalidManifest, seq.ID)
+			}
+			merged.Sequences = append(merged.Sequences, seq)
+			continue
+		}
+		merged.Sequences[i].patch(seq)
+	}
+	return merged, nil
+}
+
+// patch replaces the fields of the sequence with the ones set in other.
+func (s *Sequence) patch(other Sequence) {
+	if other.Language != "" {
+		s.Language = other.Language
+	}
+	if other.Label != "" {
+		s.Label = other.Label
+	}
+	if other.Bitrate != nil {
+		s.Bitrate = other.Bitrate
+	}
+	if other.AvgBitrate != nil {
+		s.AvgBitrate = other.AvgBitrate
+	}
+	if len(other.Clips) > 0 {
+		s.Clips = other.Clips
+	}
+}
+
+// Validate checks that the mapping can be handled by nginx-vod-module: it must
+// contain at least one sequence, all sequences must have the same number of
+// clips, and durations (when set) must match the number of clips.
+func (m Mapping) Validate() error {
+	if err := validatePlaylistType(m.PlaylistType); err != nil {
+		return err
+	}
+	if len(m.Sequences) == 0 {
+		return errors.New("missing sequences")
+	}
+	clips := len(m.Sequences[0].Clips)
+	for i, seq := range m.Sequences {
+		if len(seq.Clips) == 0 {
+			return fmt.Errorf("sequence %d: missing clips", i)
+		}
+		if len(seq.Clips) != clips {
+			return fmt.Errorf("sequence %d: got %d clips, want %d", i, len(seq.Clips), clips)
+		}
+		for j, clip := range seq.Clips {
+			if err := clip.Validate(); err != nil {
+				return fmt.Errorf("sequence %d, clip %d: %v", i, j, err)
+			}
+		}
+	}
+	if len(m.Durations) > 0 && len(m.Durations) != clips {
+		return fmt.Errorf("got %d durations, want %d", len(m.Durations), clips)
+	}
+	return nil
+}
+
+func validatePlaylistType(typ string) error {
+	switch typ {
+	case "", PlaylistTypeVOD, PlaylistTypeLive:
+		return nil
+	}
+	return fmt.Errorf("invalid playlist type %q", typ)
+}
+
+// Validate checks that the fields required by the type of the clip are set,
+// including nested clips in filters.
+func (c Clip) Validate() error {
+	switch c.Type {
+	case ClipTypeSource:
+		if c.Path == "" {
+			return errors.New("source clip: missing path")
+		}
+	case ClipTypeRate, ClipTypeGain:
+		if c.Type == ClipTypeRate && c.Rate == 0 {
+			return errors.New("rateFilter clip: missing rate")
+		}
+		if c.Type == ClipTypeGain && c.Gain == 0 {
+			return errors.New("gainFilter clip: missing gain")
+		}
+		if c.Source == nil {
+			return fmt.Errorf("%s clip: missing source", c.Type)
+		}
+		return c.Source.Validate()
+	case ClipTypeMix:
+		if len(c.Sources) == 0 {
+			return errors.New("mixFilter clip: missing sources")
+		}
+		for _, source := range c.Sources {
+			if err := source.Validate(); err != nil {
+				return err
+			}
+		}
+	case ClipTypeConcat:
+		if len(c.Paths) == 0 && len(c.ClipIDs) == 0 {
+			return errors.New("concat clip: missing paths or clipIds")
+		}
+		if n := len(c.Paths) + len(c.ClipIDs); len(c.Durations) != n {
+			return fmt.Errorf("concat clip: got %d durations, want %d", len(c.Durations), n)
+		}
+	case ClipTypeDynamic:
+		if c.ID == "" {
+			return errors.New("dynamic clip: missing id")
+		}
+	default:
+		return fmt.Errorf("invalid clip type %q", c.Type)
+	}
+	return nil
+}
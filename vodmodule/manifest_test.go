@@ -0,0 +1,167 @@
+package vodmodule
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMapManifest(t *testing.T) {
+	server, bucket := fakeBucketHandle(t, "my-bucket")
+	defer server.Stop()
+	mapper := NewMapper(bucket)
+	naming := &NamingConvention{Pattern: regexp.MustCompile(`^(?P<id>.+)\.\w+$`)}
+
+	tests := []struct {
+		name     string
+		input    MapOptions
+		expected Mapping
+	}{
+		{
+			"merge",
+			MapOptions{Prefix: "videos/edited/", Naming: naming, Manifest: "mapping.json"},
+			Mapping{
+				ID: "edited",
+				Sequences: []Sequence{
+					{
+						ID:    "captions_en",
+						Label: "English (CC)",
+						Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/edited/captions_en.vtt"}},
+					},
+					{
+						ID:    "video_480p",
+						Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/edited/video_480p.mp4"}},
+					},
+					{
+						ID:    "audio",
+						Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/edited/video_480p.mp4", Tracks: "a1"}},
+					},
+				},
+			},
+		},
+		{
+			"override",
+			MapOptions{Prefix: "videos/trimmed/", Manifest: "mapping.json"},
+			Mapping{
+				Sequences: []Sequence{
+					{
+						Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/trimmed/video_480p.mp4", ClipFrom: 5000, ClipTo: 20000}},
+					},
+				},
+			},
+		},
+		{
+			"prefix without trailing slash",
+			MapOptions{Prefix: "videos/trimmed", Manifest: "mapping.json"},
+			Mapping{
+				Sequences: []Sequence{
+					{
+						Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/trimmed/video_480p.mp4", ClipFrom: 5000, ClipTo: 20000}},
+					},
+				},
+			},
+		},
+		{
+			"no manifest",
+			MapOptions{Prefix: "videos/parts/", Filter: regexp.MustCompile(`^part1_`), Manifest: "mapping.json"},
+			Mapping{
+				Sequences: []Sequence{
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/parts/part1_480p.mp4"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/parts/part1_720p.mp4"}}},
+				},
+			},
+		},
+		{
+			"manifest disabled",
+			MapOptions{Prefix: "videos/trimmed/"},
+			Mapping{
+				Sequences: []Sequence{
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/trimmed/mapping.json"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/trimmed/video_480p.mp4"}}},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			m, err := mapper.Map(context.TODO(), test.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(m, test.expected); diff != "" {
+				t.Errorf("wrong mapping returned\nwant %#v\ngot  %#v\ndiff: %v", test.expected, m, diff)
+			}
+		})
+	}
+}
+
+func TestMapInvalidManifest(t *testing.T) {
+	server, bucket := fakeBucketHandle(t, "my-bucket")
+	defer server.Stop()
+	_, err := NewMapper(bucket).Map(context.TODO(), MapOptions{Prefix: "videos/broken/", Manifest: "mapping.json"})
+	if err == nil || !strings.Contains(err.Error(), `invalid manifest "videos/broken/mapping.json"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMappingValidate(t *testing.T) {
+	for _, example := range mappingExamples {
+		var m Mapping
+		if err := json.Unmarshal([]byte(example.json), &m); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Validate(); err != nil {
+			t.Errorf("%s: unexpected error: %v", example.name, err)
+		}
+	}
+
+	source := Clip{Type: ClipTypeSource, Path: "/my-bucket/video.mp4"}
+	invalid := []struct {
+		name    string
+		mapping Mapping
+	}{
+		{"no sequences", Mapping{}},
+		{"no clips", Mapping{Sequences: []Sequence{{}}}},
+		{"invalid playlist type", Mapping{PlaylistType: "dvr", Sequences: []Sequence{{Clips: []Clip{source}}}}},
+		{"source without path", Mapping{Sequences: []Sequence{{Clips: []Clip{{Type: ClipTypeSource}}}}}},
+		{"unknown clip type", Mapping{Sequences: []Sequence{{Clips: []Clip{{Type: "speedFilter"}}}}}},
+		{"filter without source", Mapping{Sequences: []Sequence{{Clips: []Clip{{Type: ClipTypeRate, Rate: 2}}}}}},
+		{"invalid nested clip", Mapping{Sequences: []Sequence{{Clips: []Clip{{Type: ClipTypeMix, Sources: []Clip{{Type: ClipTypeDynamic}}}}}}}},
+		{"concat without durations", Mapping{Sequences: []Sequence{{Clips: []Clip{{Type: ClipTypeConcat, Paths: []string{"a.mp4"}}}}}}},
+		{"different number of clips", Mapping{Sequences: []Sequence{{Clips: []Clip{source}}, {Clips: []Clip{source, source}}}}},
+		{"wrong number of durations", Mapping{Durations: []int64{1000, 2000}, Sequences: []Sequence{{Clips: []Clip{source}}}}},
+	}
+	for _, test := range invalid {
+		if err := test.mapping.Validate(); err == nil {
+			t.Errorf("%s: unexpected <nil> error", test.name)
+		}
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest Manifest
+		valid    bool
+	}{
+		{"partial sequence", Manifest{Mapping: Mapping{Sequences: []Sequence{{ID: "video", Label: "Video"}}}}, true},
+		{"top-level fields only", Manifest{Mode: ManifestMerge, Mapping: Mapping{ID: "video"}}, true},
+		{"invalid mode", Manifest{Mode: "replace"}, false},
+		{"sequence without id or clips", Manifest{Mapping: Mapping{Sequences: []Sequence{{Label: "Video"}}}}, false},
+		{"partial sequence in override", Manifest{Mode: ManifestOverride, Mapping: Mapping{Sequences: []Sequence{{ID: "video"}}}}, false},
+	}
+	for _, test := range tests {
+		err := test.manifest.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: unexpected <nil> error", test.name)
+		}
+	}
+}
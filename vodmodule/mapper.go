@@ -2,10 +2,14 @@ package vodmodule
 
 import (
 	"context"
+	"fmt"
 	"path"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule/mp4"
 	"google.golang.org/api/iterator"
 )
 
@@ -14,12 +18,18 @@ const maxTries = 5
 // Mapper provides the ability of mapping objects on a GCS bucket in the format
 // expected by nginx-vod-module.
 type Mapper struct {
-	bucket *storage.BucketHandle
+	bucket Bucket
 }
 
 // NewMapper returns a mapper that will map content for prefix in the given
 // BucketHandle.
 func NewMapper(bucket *storage.BucketHandle) *Mapper {
+	return NewBucketMapper(GCSBucket(bucket))
+}
+
+// NewBucketMapper returns a mapper that will map content for prefix in the
+// given Bucket, which may be backed by any storage backend.
+func NewBucketMapper(bucket Bucket) *Mapper {
 	return &Mapper{bucket: bucket}
 }
 
@@ -27,8 +37,80 @@ func NewMapper(bucket *storage.BucketHandle) *Mapper {
 type MapOptions struct {
 	Prefix string
 
-	// Optional regexp that is used to filter the list of objects.
+	// Optional list of additional prefixes, mapped along with Prefix into
+	// a single Mapping (for example, a separate prefix with captions).
+	Prefixes []string
+
+	// Recursive includes objects in "subdirectories" of the prefixes, up
+	// to MaxDepth levels below each prefix (0 means no limit).
+	Recursive bool
+	MaxDepth  int
+
+	// Optional regexp that is used to filter the list of objects. When
+	// Naming is nil and Filter contains named groups, it's also used as the
+	// naming convention.
 	Filter *regexp.Regexp
+
+	// Optional regexp that excludes objects whose base name matches it.
+	Exclude *regexp.Regexp
+
+	// Optional filters on the custom metadata of objects, which must all
+	// match. The local and S3 backends don't list custom metadata, so
+	// objects never match in those backends.
+	MetadataFilters []MetadataFilter
+
+	// Optional list of content types (like "video/mp4" or "video/*"). When
+	// set, objects with other content types are ignored.
+	ContentTypes []string
+
+	// Optional naming convention used to extract the metadata of
+	// sequences from file names, and to group clips into sequences.
+	Naming *NamingConvention
+
+	// Order defines how sequences are sorted. By default, sequences are
+	// sorted by name in lexical order.
+	Order Ordering
+
+	// Optional name of the manifest object, relative to Prefix (for
+	// example, "mapping.json"). When the object exists, it's applied to the
+	// mapping according to its mode, and it's never mapped as a sequence.
+	Manifest string
+
+	// Optional template for the paths of clips. By default, paths are in
+	// the format "/<bucket>/<object>" (see DefaultClipPath).
+	ClipPath *ClipPathTemplate
+
+	// Optional prober used to read the metadata of MP4 files. Probes fill
+	// the average bitrate, the language and the media info of sequences,
+	// along with the durations of clips in multi-clip mappings. Files that
+	// can't be probed are mapped without metadata.
+	Prober *Prober
+}
+
+// manifestName returns the name of the manifest object, which lives under
+// the prefix even when it doesn't end in a slash.
+func (o MapOptions) manifestName() string {
+	if o.Prefix == "" || strings.HasSuffix(o.Prefix, "/") {
+		return o.Prefix + o.Manifest
+	}
+	return o.Prefix + "/" + o.Manifest
+}
+
+// naming returns the naming convention that should be used for the options.
+func (o MapOptions) naming() *NamingConvention {
+	if o.Naming == nil && o.Filter != nil && hasNamedGroups(o.Filter) {
+		return &NamingConvention{Pattern: o.Filter}
+	}
+	return o.Naming
+}
+
+func hasNamedGroups(re *regexp.Regexp) bool {
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			return true
+		}
+	}
+	return false
 }
 
 // Map returns a Mapping object with the list of objects that match the given
@@ -36,33 +118,159 @@ type MapOptions struct {
 // example, if the caller only wants to return objects that with the ``.mp4``
 // extension).
 func (m *Mapper) Map(ctx context.Context, opts MapOptions) (Mapping, error) {
-	var err error
+	var (
+		manifest *Manifest
+		err      error
+	)
+	if opts.Manifest != "" {
+		manifest, err = LoadManifest(ctx, m.bucket, opts.manifestName())
+		if err != nil {
+			return Mapping{}, err
+		}
+		if manifest != nil && manifest.Mode == ManifestOverride {
+			return manifest.Mapping, nil
+		}
+	}
 	r := Mapping{}
-	r.Sequences, err = m.getSequences(ctx, opts.Prefix, opts.Filter)
-	return r, err
+	r.Sequences, r.Durations, err = m.getSequences(ctx, opts)
+	if err != nil || manifest == nil {
+		return r, err
+	}
+	r, err = manifest.apply(r)
+	if err != nil {
+		return r, err
+	}
+	if err = r.Validate(); err != nil {
+		return r, fmt.Errorf("vodmodule: invalid mapping after applying manifest %q: %v", opts.manifestName(), err)
+	}
+	return r, nil
 }
 
-func (m *Mapper) getSequences(ctx context.Context, prefix string, filter *regexp.Regexp) ([]Sequence, error) {
+// getSequences returns the sequences for the objects that match the options,
+// along with the durations of the clips, when they're known.
+func (m *Mapper) getSequences(ctx context.Context, opts MapOptions) ([]Sequence, []int64, error) {
+	entries, err := m.getEntries(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	seqs := make([]Sequence, len(entries))
+	for i, e := range entries {
+		seqs[i] = e.seq
+	}
+	return seqs, clipDurations(entries), nil
+}
+
+// getEntries lists the objects that match the options and returns them as
+// sorted entries, with one entry per sequence.
+func (m *Mapper) getEntries(ctx context.Context, opts MapOptions) ([]entry, error) {
+	var objs []*storage.ObjectAttrs
+	seen := map[string]bool{}
+	for _, prefix := range append([]string{opts.Prefix}, opts.Prefixes...) {
+		list, err := m.listObjects(ctx, prefix, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range list {
+			// prefixes may overlap, but each object is mapped only once.
+			if !seen[obj.Name] {
+				seen[obj.Name] = true
+				objs = append(objs, obj)
+			}
+		}
+	}
+
+	var probes map[string]*mp4.Info
+	if opts.Prober != nil {
+		probes = opts.Prober.probeAll(ctx, m.bucket, objs)
+	}
+
+	naming := opts.naming()
+	entries := []entry{}
+	groups := map[string]int{}
+	for _, obj := range objs {
+		filename := path.Base(obj.Name)
+		clip := Clip{Type: ClipTypeSource, Path: opts.ClipPath.path(obj)}
+		duration := metadataDuration(obj)
+		info, probed := probes[obj.Name]
+		if probed {
+			duration = info.DurationMillis()
+		}
+		captures := naming.captures(filename)
+		if group, ok := captures["group"]; ok {
+			if idx, ok := groups[group]; ok {
+				entries[idx].seq.Clips = append(entries[idx].seq.Clips, clip)
+				entries[idx].durations = append(entries[idx].durations, duration)
+				continue
+			}
+			groups[group] = len(entries)
+		}
+		seq := Sequence{Clips: []Clip{clip}, Duration: duration}
+		naming.apply(&seq, filename, captures)
+		if probed {
+			applyProbe(&seq, info, naming)
+		}
+		entries = append(entries, entry{
+			seq:       seq,
+			name:      obj.Name,
+			captures:  captures,
+			durations: []int64{duration},
+		})
+	}
+	opts.Order.sort(entries)
+	return entries, nil
+}
+
+// metadataDuration returns the duration of the object in milliseconds, as
+// stored in the "duration" metadata key, or zero.
+func metadataDuration(obj *storage.ObjectAttrs) int64 {
+	duration, err := strconv.ParseInt(obj.Metadata["duration"], 10, 64)
+	if err != nil || duration < 0 {
+		return 0
+	}
+	return duration
+}
+
+// listObjects returns the objects under prefix that match the filter,
+// retrying failed listings.
+func (m *Mapper) listObjects(ctx context.Context, prefix string, opts MapOptions) ([]*storage.ObjectAttrs, error) {
+	query := storage.Query{Prefix: prefix, Delimiter: "/"}
+	if opts.Recursive {
+		query.Delimiter = ""
+	}
 	var err error
 	for i := 0; i < maxTries; i++ {
-		iter := m.bucket.Objects(ctx, &storage.Query{
-			Prefix:    prefix,
-			Delimiter: "/",
-		})
-		seqs := []Sequence{}
+		iter := m.bucket.Objects(ctx, &query)
+		objs := []*storage.ObjectAttrs{}
 		var obj *storage.ObjectAttrs
 		obj, err = iter.Next()
 		for ; err == nil; obj, err = iter.Next() {
-			filename := path.Base(obj.Name)
-			if filter == nil || filter.MatchString(filename) {
-				seqs = append(seqs, Sequence{
-					Clips: []Clip{{Type: "source", Path: "/" + obj.Bucket + "/" + obj.Name}},
-				})
+			if obj.Prefix != "" || !opts.withinDepth(prefix, obj.Name) {
+				continue
+			}
+			if opts.Manifest != "" && obj.Name == opts.manifestName() {
+				continue
+			}
+			if opts.match(obj) {
+				objs = append(objs, obj)
 			}
 		}
 		if err == iterator.Done {
-			return seqs, nil
+			return objs, nil
 		}
 	}
 	return nil, err
 }
+
+// withinDepth reports whether the object is at most MaxDepth levels below
+// prefix. A prefix without the trailing slash counts from the same directory,
+// so "videos/video" and "videos/video/" have the same depth.
+func (o MapOptions) withinDepth(prefix, name string) bool {
+	if !o.Recursive || o.MaxDepth <= 0 {
+		return true
+	}
+	rest := strings.TrimPrefix(name, prefix)
+	if !strings.HasSuffix(prefix, "/") {
+		rest = strings.TrimPrefix(rest, "/")
+	}
+	return strings.Count(rest, "/") <= o.MaxDepth
+}
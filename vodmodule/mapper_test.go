@@ -82,6 +82,254 @@ func TestMap(t *testing.T) {
 				},
 			},
 		},
+		{
+			"captions with naming convention",
+			MapOptions{
+				Prefix: "videos/video/",
+				Filter: regexp.MustCompile(`\.vtt$`),
+				Naming: &NamingConvention{
+					Pattern:   regexp.MustCompile(`^(?P<id>\d+)_\d+_caption_(?P<lang>[a-z]+)_`),
+					Languages: map[string]Language{"wg": {Code: "eng", Label: "English"}},
+				},
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{
+						ID:       "77071",
+						Language: "eng",
+						Label:    "English",
+						Clips: []Clip{
+							{Type: "source", Path: "/my-bucket/videos/video/77071_1_caption_wg_240p_001f8ea7-749b-4d43-7bd5-b357e4e24f32.vtt"},
+						},
+					},
+				},
+			},
+		},
+		{
+			"named groups in filter",
+			MapOptions{
+				Prefix: "videos/renditions/",
+				Filter: regexp.MustCompile(`_(?:(?P<lang>[a-z]{2,3})_)?(?P<bitrate>\d+)k\.(mp4|m4a)$`),
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{
+						Bitrate: &Bitrate{Video: 4500000},
+						Clips: []Clip{
+							{Type: "source", Path: "/my-bucket/videos/renditions/show_1080p_4500k.mp4"},
+						},
+					},
+					{
+						Bitrate: &Bitrate{Video: 2500000},
+						Clips: []Clip{
+							{Type: "source", Path: "/my-bucket/videos/renditions/show_720p_2500k.mp4"},
+						},
+					},
+					{
+						Language: "eng",
+						Label:    "English",
+						Bitrate:  &Bitrate{Audio: 128000},
+						Clips: []Clip{
+							{Type: "source", Path: "/my-bucket/videos/renditions/show_audio_en_128k.m4a"},
+						},
+					},
+				},
+			},
+		},
+		{
+			"grouped clips",
+			MapOptions{
+				Prefix: "videos/parts/",
+				Naming: &NamingConvention{
+					Pattern: regexp.MustCompile(`^part\d+_(?P<id>(?P<group>\d+)p)\.mp4$`),
+				},
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{
+						ID: "480p",
+						Clips: []Clip{
+							{Type: "source", Path: "/my-bucket/videos/parts/part1_480p.mp4"},
+							{Type: "source", Path: "/my-bucket/videos/parts/part2_480p.mp4"},
+						},
+					},
+					{
+						ID: "720p",
+						Clips: []Clip{
+							{Type: "source", Path: "/my-bucket/videos/parts/part1_720p.mp4"},
+							{Type: "source", Path: "/my-bucket/videos/parts/part2_720p.mp4"},
+						},
+					},
+				},
+			},
+		},
+		{
+			"ordered by captured height, media first",
+			MapOptions{
+				Prefix: "videos/video/",
+				Filter: regexp.MustCompile(`(?P<height>\d+)p(\.mp4|_.*\.vtt)$`),
+				Order:  Ordering{By: "height", Descending: true, MediaFirst: true},
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{
+						Height: 1080,
+						Clips: []Clip{
+							{Type: "source", Path: "/my-bucket/videos/video/28043_1_video_1080p.mp4"},
+						},
+					},
+					{
+						Height: 720,
+						Clips: []Clip{
+							{Type: "source", Path: "/my-bucket/videos/video/video1_720p.mp4"},
+						},
+					},
+					{
+						Height: 480,
+						Clips: []Clip{
+							{Type: "source", Path: "/my-bucket/videos/video/video1_480p.mp4"},
+						},
+					},
+					{
+						Height: 240,
+						Clips: []Clip{
+							{Type: "source", Path: "/my-bucket/videos/video/77071_1_caption_wg_240p_001f8ea7-749b-4d43-7bd5-b357e4e24f32.vtt"},
+						},
+					},
+				},
+			},
+		},
+		{
+			"subdirectories are skipped",
+			MapOptions{
+				Prefix: "musics/music/",
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/musics/music/music1.txt"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/musics/music/music2.txt"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/musics/music/music3.txt"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/musics/music/music4.mp3"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/musics/music/music5.wav"}}},
+				},
+			},
+		},
+		{
+			"recursive",
+			MapOptions{
+				Prefix:    "musics/music/",
+				Filter:    regexp.MustCompile(`\.mp3$`),
+				Recursive: true,
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/musics/music/music/4.mp3"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/musics/music/music4.mp3"}}},
+				},
+			},
+		},
+		{
+			"recursive with max depth",
+			MapOptions{
+				Prefix:    "musics/",
+				Filter:    regexp.MustCompile(`\.mp3$`),
+				Recursive: true,
+				MaxDepth:  1,
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/musics/music/music4.mp3"}}},
+				},
+			},
+		},
+		{
+			"recursive with max depth, prefix without trailing slash",
+			MapOptions{
+				Prefix:    "musics",
+				Filter:    regexp.MustCompile(`\.mp3$`),
+				Recursive: true,
+				MaxDepth:  1,
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/musics/music/music4.mp3"}}},
+				},
+			},
+		},
+		{
+			"multiple prefixes",
+			MapOptions{
+				Prefix:   "videos/video/",
+				Prefixes: []string{"subs/", "videos/video/"},
+				Filter:   regexp.MustCompile(`^video1(_480p\.mp4|\.srt)$`),
+				Order:    Ordering{MediaFirst: true},
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/video/video1_480p.mp4"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/subs/video1.srt"}}},
+				},
+			},
+		},
+		{
+			"exclude",
+			MapOptions{
+				Prefix:  "videos/published/",
+				Filter:  regexp.MustCompile(`\.mp4$`),
+				Exclude: regexp.MustCompile(`_1080p`),
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/published/video_480p.mp4"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/published/video_720p.mp4"}}},
+				},
+			},
+		},
+		{
+			"metadata filters",
+			MapOptions{
+				Prefix: "videos/published/",
+				MetadataFilters: []MetadataFilter{
+					{Key: "status", Value: "ready"},
+					{Key: "Language", Exists: true},
+				},
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/published/captions_es.vtt"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/published/video_480p.mp4"}}},
+				},
+			},
+		},
+		{
+			"metadata pattern",
+			MapOptions{
+				Prefix:          "videos/published/",
+				MetadataFilters: []MetadataFilter{{Key: "status", Pattern: regexp.MustCompile(`^(ready|transcoding)$`)}},
+				ContentTypes:    []string{"video/mp4"},
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/published/video_480p.mp4"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/published/video_720p.mp4"}}},
+				},
+			},
+		},
+		{
+			"content types",
+			MapOptions{
+				Prefix:       "videos/published/",
+				ContentTypes: []string{"video/*", "TEXT/VTT"},
+			},
+			Mapping{
+				Sequences: []Sequence{
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/published/captions_es.vtt"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/published/video_1080p.mp4"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/published/video_480p.mp4"}}},
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/videos/published/video_720p.mp4"}}},
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
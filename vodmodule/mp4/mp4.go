@@ -0,0 +1,495 @@
+// Package mp4 reads the metadata of MP4 files (the "moov" box), without
+// downloading the media data.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Track kinds, derived from the handler type of the track.
+const (
+	KindVideo = "video"
+	KindAudio = "audio"
+	KindText  = "text"
+)
+
+// ErrNoMoov is returned when the file doesn't contain a "moov" box.
+var ErrNoMoov = errors.New("mp4: moov box not found")
+
+// maxMoovSize limits the size of the "moov" box that is loaded in memory.
+const maxMoovSize = 64 << 20
+
+// Info contains the metadata of an MP4 file.
+type Info struct {
+	// Timescale is the number of time units per second used by Duration.
+	Timescale uint32
+	Duration  uint64
+	Tracks    []Track
+
+	// Init is the range of the boxes up to the end of "moov", and Index the
+	// range of the "sidx" box that follows it, nil when there's none. In
+	// fragmented files, they're the initialization segment and the segment
+	// index used by the on-demand profile of DASH.
+	Init  Range
+	Index *Range
+}
+
+// Range is a range of bytes in a file, with inclusive offsets as in the Range
+// header of HTTP.
+type Range struct {
+	First int64
+	Last  int64
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// Track contains the metadata of a single track.
+type Track struct {
+	ID        uint32
+	Kind      string
+	Codec     string
+	Timescale uint32
+	Duration  uint64
+	Width     int
+	Height    int
+
+	// Language is the ISO-639-2 code of the language of the track, empty
+	// when undefined.
+	Language string
+
+	// Size is the total size of the samples in the track, in bytes.
+	Size int64
+}
+
+// DurationMillis returns the duration of the file in milliseconds.
+func (i *Info) DurationMillis() int64 {
+	return millis(i.Duration, i.Timescale)
+}
+
+// Track returns the first track of the given kind, or nil.
+func (i *Info) Track(kind string) *Track {
+	for j := range i.Tracks {
+		if i.Tracks[j].Kind == kind {
+			return &i.Tracks[j]
+		}
+	}
+	return nil
+}
+
+// Codecs returns the codecs of all tracks, as used in the "codecs" parameter
+// of media types (RFC 6381).
+func (i *Info) Codecs() []string {
+	var codecs []string
+	for _, track := range i.Tracks {
+		if track.Codec != "" {
+			codecs = append(codecs, track.Codec)
+		}
+	}
+	return codecs
+}
+
+// DurationMillis returns the duration of the track in milliseconds.
+func (t *Track) DurationMillis() int64 {
+	return millis(t.Duration, t.Timescale)
+}
+
+// Bitrate returns the average bitrate of the track in bits per second.
+func (t *Track) Bitrate() int64 {
+	ms := t.DurationMillis()
+	if ms <= 0 {
+		return 0
+	}
+	return t.Size * 8 * 1000 / ms
+}
+
+func millis(duration uint64, timescale uint32) int64 {
+	if timescale == 0 {
+		return 0
+	}
+	return int64(duration * 1000 / uint64(timescale))
+}
+
+// Parse reads the metadata of the MP4 file with the given size. It only reads
+// the headers of top-level boxes and the content of the "moov" box, so it can
+// be used with ranged reads on remote files.
+func Parse(r io.ReaderAt, size int64) (*Info, error) {
+	var offset int64
+	for offset+8 <= size {
+		typ, boxSize, headerSize, err := readHeader(r, offset, size)
+		if err != nil {
+			return nil, err
+		}
+		if typ == "moov" {
+			if boxSize > maxMoovSize {
+				return nil, fmt.Errorf("mp4: moov box is too large (%d bytes)", boxSize)
+			}
+			if offset+boxSize > size {
+				return nil, errors.New("mp4: truncated moov box")
+			}
+			data := make([]byte, boxSize-headerSize)
+			n, err := r.ReadAt(data, offset+headerSize)
+			if n < len(data) {
+				if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			info, err := ParseMoov(data)
+			if err != nil {
+				return nil, err
+			}
+			info.Init = Range{First: 0, Last: offset + boxSize - 1}
+			info.Index, err = findIndex(r, offset+boxSize, size)
+			return info, err
+		}
+		offset += boxSize
+	}
+	return nil, ErrNoMoov
+}
+
+// findIndex looks for the "sidx" box in the top-level boxes that follow
+// "moov", stopping at the media data.
+func findIndex(r io.ReaderAt, offset, size int64) (*Range, error) {
+	for offset+8 <= size {
+		typ, boxSize, _, err := readHeader(r, offset, size)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case "sidx":
+			return &Range{First: offset, Last: offset + boxSize - 1}, nil
+		case "moof", "mdat":
+			return nil, nil
+		}
+		offset += boxSize
+	}
+	return nil, nil
+}
+
+// readHeader reads the header of the box at the given offset, returning its
+// type, its total size and the size of the header.
+func readHeader(r io.ReaderAt, offset, size int64) (typ string, boxSize, headerSize int64, err error) {
+	header := make([]byte, 16)
+	n, err := r.ReadAt(header, offset)
+	if n < 8 {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", 0, 0, err
+	}
+	boxSize = int64(binary.BigEndian.Uint32(header))
+	typ = string(header[4:8])
+	headerSize = 8
+	switch boxSize {
+	case 0:
+		boxSize = size - offset
+	case 1:
+		if n < 16 {
+			return "", 0, 0, io.ErrUnexpectedEOF
+		}
+		boxSize = int64(binary.BigEndian.Uint64(header[8:]))
+		headerSize = 16
+	}
+	if boxSize < headerSize {
+		return "", 0, 0, fmt.Errorf("mp4: invalid size %d for box %q", boxSize, typ)
+	}
+	return typ, boxSize, headerSize, nil
+}
+
+// ParseMoov parses the content of a "moov" box, without its header.
+func ParseMoov(data []byte) (*Info, error) {
+	boxes, err := readBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+	var info Info
+	for _, b := range boxes {
+		switch b.typ {
+		case "mvhd":
+			info.Timescale, info.Duration, err = parseTimes(b.data, 12, 20)
+		case "trak":
+			var track *Track
+			track, err = parseTrak(b.data)
+			if track != nil {
+				info.Tracks = append(info.Tracks, *track)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if info.Timescale == 0 {
+		return nil, errors.New("mp4: missing or invalid mvhd box")
+	}
+	return &info, nil
+}
+
+type box struct {
+	typ  string
+	data []byte
+}
+
+func readBoxes(data []byte) ([]box, error) {
+	var boxes []box
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("mp4: truncated box %q", typ)
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return nil, fmt.Errorf("mp4: invalid size %d for box %q", size, typ)
+		}
+		boxes = append(boxes, box{typ: typ, data: data[headerSize:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// child returns the data of the first box with the given path, or nil.
+func child(data []byte, path ...string) []byte {
+	for _, typ := range path {
+		boxes, err := readBoxes(data)
+		if err != nil {
+			return nil
+		}
+		data = nil
+		for _, b := range boxes {
+			if b.typ == typ {
+				data = b.data
+				break
+			}
+		}
+		if data == nil {
+			return nil
+		}
+	}
+	return data
+}
+
+// parseTimes parses the timescale and the duration of "mvhd" and "mdhd"
+// boxes, which share the same layout for those fields. The offsets are the
+// position of the timescale in version 0 and version 1 boxes.
+func parseTimes(data []byte, offsetV0, offsetV1 int) (timescale uint32, duration uint64, err error) {
+	if len(data) < 4 {
+		return 0, 0, errors.New("mp4: truncated header box")
+	}
+	if data[0] == 1 {
+		if len(data) < offsetV1+12 {
+			return 0, 0, errors.New("mp4: truncated header box")
+		}
+		return binary.BigEndian.Uint32(data[offsetV1:]), binary.BigEndian.Uint64(data[offsetV1+4:]), nil
+	}
+	if len(data) < offsetV0+8 {
+		return 0, 0, errors.New("mp4: truncated header box")
+	}
+	return binary.BigEndian.Uint32(data[offsetV0:]), uint64(binary.BigEndian.Uint32(data[offsetV0+4:])), nil
+}
+
+// parseTrak returns the metadata of a track, or nil for tracks that aren't
+// video, audio or text (like hint tracks).
+func parseTrak(data []byte) (*Track, error) {
+	var track Track
+	if tkhd := child(data, "tkhd"); len(tkhd) >= 4 {
+		idOffset, sizeOffset := 12, 76
+		if tkhd[0] == 1 {
+			idOffset, sizeOffset = 20, 88
+		}
+		if len(tkhd) >= idOffset+4 {
+			track.ID = binary.BigEndian.Uint32(tkhd[idOffset:])
+		}
+		if len(tkhd) >= sizeOffset+8 {
+			// width and height are 16.16 fixed-point numbers.
+			track.Width = int(binary.BigEndian.Uint32(tkhd[sizeOffset:]) >> 16)
+			track.Height = int(binary.BigEndian.Uint32(tkhd[sizeOffset+4:]) >> 16)
+		}
+	}
+	mdia := child(data, "mdia")
+	if mdia == nil {
+		return nil, errors.New("mp4: missing mdia box")
+	}
+	mdhd := child(mdia, "mdhd")
+	if mdhd == nil {
+		return nil, errors.New("mp4: missing mdhd box")
+	}
+	var err error
+	track.Timescale, track.Duration, err = parseTimes(mdhd, 12, 20)
+	if err != nil {
+		return nil, err
+	}
+	langOffset := 20
+	if mdhd[0] == 1 {
+		langOffset = 32
+	}
+	if len(mdhd) >= langOffset+2 {
+		track.Language = parseLanguage(binary.BigEndian.Uint16(mdhd[langOffset:]))
+	}
+
+	hdlr := child(mdia, "hdlr")
+	if len(hdlr) < 12 {
+		return nil, errors.New("mp4: missing hdlr box")
+	}
+	switch string(hdlr[8:12]) {
+	case "vide":
+		track.Kind = KindVideo
+	case "soun":
+		track.Kind = KindAudio
+	case "text", "sbtl", "subt", "clcp":
+		track.Kind = KindText
+	default:
+		return nil, nil
+	}
+
+	stbl := child(mdia, "minf", "stbl")
+	if stsd := child(stbl, "stsd"); len(stsd) >= 8 {
+		entries, err := readBoxes(stsd[8:])
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			track.Codec = parseCodec(entries[0], track.Kind)
+		}
+	}
+	if stsz := child(stbl, "stsz"); len(stsz) >= 12 {
+		sampleSize := binary.BigEndian.Uint32(stsz[4:])
+		count := int64(binary.BigEndian.Uint32(stsz[8:]))
+		if sampleSize > 0 {
+			track.Size = int64(sampleSize) * count
+		} else {
+			for i := int64(0); i < count && 12+4*i+4 <= int64(len(stsz)); i++ {
+				track.Size += int64(binary.BigEndian.Uint32(stsz[12+4*i:]))
+			}
+		}
+	}
+	return &track, nil
+}
+
+// parseLanguage decodes the packed ISO-639-2 code used in "mdhd" boxes.
+func parseLanguage(packed uint16) string {
+	code := []byte{
+		byte(packed>>10&0x1f) + 0x60,
+		byte(packed>>5&0x1f) + 0x60,
+		byte(packed&0x1f) + 0x60,
+	}
+	lang := string(code)
+	if lang == "und" || strings.Trim(lang, "abcdefghijklmnopqrstuvwxyz") != "" {
+		return ""
+	}
+	return lang
+}
+
+// sampleEntrySize is the size of the fields that precede child boxes in
+// sample entries, by track kind.
+var sampleEntrySize = map[string]int{
+	KindVideo: 78,
+	KindAudio: 28,
+}
+
+// parseCodec returns the codec of the sample entry, in the format defined by
+// RFC 6381 for the most common codecs, or the type of the entry otherwise.
+func parseCodec(entry box, kind string) string {
+	typ := entry.typ
+	var children []byte
+	if n := sampleEntrySize[kind]; len(entry.data) >= n {
+		children = entry.data[n:]
+	}
+	if typ == "encv" || typ == "enca" {
+		// encrypted entries store the original type in sinf/frma.
+		if frma := child(children, "sinf", "frma"); len(frma) >= 4 {
+			typ = string(frma[:4])
+		}
+	}
+	switch typ {
+	case "avc1", "avc3":
+		if avcC := child(children, "avcC"); len(avcC) >= 4 {
+			return fmt.Sprintf("%s.%02x%02x%02x", typ, avcC[1], avcC[2], avcC[3])
+		}
+	case "mp4a":
+		if esds := child(children, "esds"); len(esds) > 4 {
+			if codec := parseESDS(esds[4:]); codec != "" {
+				return codec
+			}
+		}
+	case "Opus":
+		return "opus"
+	}
+	return typ
+}
+
+// parseESDS returns the codec of the ES descriptor in an "esds" box, like
+// "mp4a.40.2" for AAC-LC.
+func parseESDS(data []byte) string {
+	tag, es := readDescriptor(&data)
+	if tag != 0x03 || len(es) < 3 {
+		return ""
+	}
+	flags := es[2]
+	es = es[3:]
+	if flags&0x80 != 0 && len(es) >= 2 {
+		es = es[2:]
+	}
+	if flags&0x40 != 0 && len(es) >= 1 {
+		n := int(es[0]) + 1
+		if len(es) < n {
+			return ""
+		}
+		es = es[n:]
+	}
+	if flags&0x20 != 0 && len(es) >= 2 {
+		es = es[2:]
+	}
+	tag, config := readDescriptor(&es)
+	if tag != 0x04 || len(config) < 13 {
+		return ""
+	}
+	objectType := config[0]
+	codec := fmt.Sprintf("mp4a.%02x", objectType)
+	rest := config[13:]
+	if tag, specific := readDescriptor(&rest); tag == 0x05 && len(specific) >= 1 {
+		aot := int(specific[0] >> 3)
+		if aot == 31 && len(specific) >= 2 {
+			aot = 32 + (int(specific[0]&0x07)<<3 | int(specific[1]>>5))
+		}
+		codec += fmt.Sprintf(".%d", aot)
+	}
+	return codec
+}
+
+// readDescriptor reads an MPEG-4 descriptor from data, returning its tag and
+// content, and advancing data past it.
+func readDescriptor(data *[]byte) (byte, []byte) {
+	d := *data
+	if len(d) < 2 {
+		return 0, nil
+	}
+	tag := d[0]
+	d = d[1:]
+	size := 0
+	for i := 0; i < 4 && len(d) > 0; i++ {
+		b := d[0]
+		d = d[1:]
+		size = size<<7 | int(b&0x7f)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if size > len(d) {
+		return 0, nil
+	}
+	*data = d[size:]
+	return tag, d[:size]
+}
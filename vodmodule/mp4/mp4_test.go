@@ -0,0 +1,113 @@
+package mp4
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/NYTimes/gcs-helper/v3/internal/testhelper"
+)
+
+func TestParse(t *testing.T) {
+	opts := testhelper.MP4Options{
+		Duration:     10000,
+		Width:        1280,
+		Height:       720,
+		VideoBitrate: 2000000,
+		AudioBitrate: 128000,
+		Language:     "por",
+	}
+	expected := &Info{
+		Timescale: 1000,
+		Duration:  10000,
+		Tracks: []Track{
+			{ID: 1, Kind: KindVideo, Codec: "avc1.64001f", Timescale: 1000, Duration: 10000, Width: 1280, Height: 720, Size: 2500000},
+			{ID: 2, Kind: KindAudio, Codec: "mp4a.40.2", Timescale: 1000, Duration: 10000, Language: "por", Size: 160000},
+		},
+	}
+	// sizes of the mdat and sidx boxes generated by FakeMP4.
+	const mdatSize, sidxSize = 1032, 40
+	tests := []struct {
+		name         string
+		moovAtEnd    bool
+		segmentIndex bool
+		init         func(size int64) Range
+		index        func(size int64) *Range
+	}{
+		{
+			"moov first", false, false,
+			func(size int64) Range { return Range{0, size - mdatSize - 1} },
+			func(size int64) *Range { return nil },
+		},
+		{
+			"moov at end", true, false,
+			func(size int64) Range { return Range{0, size - 1} },
+			func(size int64) *Range { return nil },
+		},
+		{
+			"segment index", false, true,
+			func(size int64) Range { return Range{0, size - mdatSize - sidxSize - 1} },
+			func(size int64) *Range { return &Range{size - mdatSize - sidxSize, size - mdatSize - 1} },
+		},
+	}
+	for _, test := range tests {
+		opts.MoovAtEnd, opts.SegmentIndex = test.moovAtEnd, test.segmentIndex
+		data := testhelper.FakeMP4(opts)
+		info, err := Parse(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected.Init, expected.Index = test.init(int64(len(data))), test.index(int64(len(data)))
+		if !reflect.DeepEqual(info, expected) {
+			t.Errorf("%s: wrong info returned\nwant %#v\ngot  %#v", test.name, expected, info)
+		}
+	}
+}
+
+func TestParseAudioOnly(t *testing.T) {
+	data := testhelper.FakeMP4(testhelper.MP4Options{Duration: 4500, AudioBitrate: 64000})
+	info, err := Parse(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.DurationMillis() != 4500 {
+		t.Errorf("wrong duration\nwant 4500\ngot  %d", info.DurationMillis())
+	}
+	if info.Track(KindVideo) != nil {
+		t.Errorf("unexpected video track: %#v", info.Track(KindVideo))
+	}
+	audio := info.Track(KindAudio)
+	if audio == nil {
+		t.Fatal("missing audio track")
+	}
+	if audio.Language != "" {
+		t.Errorf("wrong language\nwant %q\ngot  %q", "", audio.Language)
+	}
+	if audio.Bitrate() != 64000 {
+		t.Errorf("wrong bitrate\nwant 64000\ngot  %d", audio.Bitrate())
+	}
+	if codecs := info.Codecs(); !reflect.DeepEqual(codecs, []string{"mp4a.40.2"}) {
+		t.Errorf("wrong codecs returned: %q", codecs)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	opts := testhelper.MP4Options{Duration: 1000, Width: 640, Height: 360, VideoBitrate: 500000, MoovAtEnd: true}
+	valid := testhelper.FakeMP4(opts)
+	moovOffset := bytes.Index(valid, []byte("moov")) - 4
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not an mp4 file", []byte("WEBVTT\n\n00:00.000 --> 00:01.000\nhello\n")},
+		{"no moov box", valid[:moovOffset]},
+		{"truncated moov box", valid[:moovOffset+100]},
+		{"invalid box size", []byte{0, 0, 0, 4, 'f', 't', 'y', 'p'}},
+	}
+	for _, test := range tests {
+		_, err := Parse(bytes.NewReader(test.data), int64(len(test.data)))
+		if err == nil {
+			t.Errorf("%s: unexpected <nil> error", test.name)
+		}
+	}
+}
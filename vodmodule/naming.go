@@ -0,0 +1,211 @@
+package vodmodule
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// audioExtensions lists the extensions of audio-only files, used to decide
+// whether a captured bitrate refers to the audio or the video track.
+var audioExtensions = map[string]bool{
+	".aac":  true,
+	".ac3":  true,
+	".eac3": true,
+	".m4a":  true,
+	".mp3":  true,
+	".oga":  true,
+	".opus": true,
+}
+
+// NamingConvention extracts sequence metadata from the base name of objects,
+// using the following named capture groups in Pattern:
+//
+//   - id: the id of the sequence
+//   - lang: a language code, resolved to an ISO-639-2 code and a label
+//   - label: the label of the sequence, overriding the language name
+//   - bitrate: the bitrate in kbps, reported as the audio bitrate for audio
+//     files and as the video bitrate otherwise
+//   - width and height: the resolution of the video, in pixels
+//   - group: objects with the same group are mapped as clips of a single
+//     sequence
+//
+// Other named groups are ignored. Objects that don't match the pattern are
+// mapped without metadata.
+type NamingConvention struct {
+	Pattern *regexp.Regexp
+
+	// Languages extends the built-in language table, mapping the captured
+	// values (case-insensitive) to languages.
+	Languages map[string]Language
+}
+
+// Language represents a language as expected by nginx-vod-module: a
+// 3-letter (ISO-639-2) code and a label.
+type Language struct {
+	Code  string
+	Label string
+}
+
+// CompileNamingTemplate compiles a naming template into a pattern that can be
+// used in NamingConvention. Templates match the whole file name, with
+// placeholders in the format "{name}" for capture groups and "{*}" for
+// ignored parts. For example, "{id}_{*}_caption_{lang}_{*}.vtt" matches
+// "77071_1_caption_wg_240p_001f8ea7.vtt".
+func CompileNamingTemplate(template string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for template != "" {
+		start := strings.Index(template, "{")
+		if start < 0 {
+			expr.WriteString(regexp.QuoteMeta(template))
+			break
+		}
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("invalid naming template: unclosed placeholder in %q", template)
+		}
+		expr.WriteString(regexp.QuoteMeta(template[:start]))
+		name := template[start+1 : start+end]
+		if name == "*" {
+			expr.WriteString(".*?")
+		} else {
+			expr.WriteString("(?P<" + name + ">.+?)")
+		}
+		template = template[start+end+1:]
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// captures returns the named groups captured from the given file name, or nil
+// if the name doesn't match the pattern.
+func (n *NamingConvention) captures(filename string) map[string]string {
+	if n == nil || n.Pattern == nil {
+		return nil
+	}
+	match := n.Pattern.FindStringSubmatch(filename)
+	if match == nil {
+		return nil
+	}
+	captures := map[string]string{}
+	for i, name := range n.Pattern.SubexpNames() {
+		if name != "" && match[i] != "" {
+			captures[name] = match[i]
+		}
+	}
+	return captures
+}
+
+// apply fills the metadata of the sequence from the groups captured from
+// filename.
+func (n *NamingConvention) apply(seq *Sequence, filename string, captures map[string]string) {
+	if captures == nil {
+		return
+	}
+	seq.ID = captures["id"]
+	if kbps, err := strconv.ParseInt(captures["bitrate"], 10, 64); err == nil && kbps > 0 {
+		if audioExtensions[strings.ToLower(path.Ext(filename))] {
+			seq.Bitrate = &Bitrate{Audio: kbps * 1000}
+		} else {
+			seq.Bitrate = &Bitrate{Video: kbps * 1000}
+		}
+	}
+	if width, err := strconv.Atoi(captures["width"]); err == nil && width > 0 {
+		seq.Width = width
+	}
+	if height, err := strconv.Atoi(captures["height"]); err == nil && height > 0 {
+		seq.Height = height
+	}
+	if code, ok := captures["lang"]; ok {
+		if lang, ok := n.LookupLanguage(code); ok {
+			seq.Language = lang.Code
+			seq.Label = lang.Label
+		}
+	}
+	if label, ok := captures["label"]; ok {
+		seq.Label = label
+	}
+}
+
+// LookupLanguage resolves the given code using Languages and the built-in
+// table, which contains ISO-639-1 and ISO-639-2 codes of common languages.
+// Region suffixes (as in "pt-BR") are ignored when the full code isn't found.
+// Unknown 3-letter codes are assumed to be valid ISO-639-2 codes.
+func (n *NamingConvention) LookupLanguage(code string) (Language, bool) {
+	code = strings.ToLower(code)
+	candidates := []string{code}
+	if i := strings.IndexAny(code, "-_"); i > 0 {
+		candidates = append(candidates, code[:i])
+	}
+	for _, candidate := range candidates {
+		if n != nil {
+			if lang, ok := n.Languages[candidate]; ok {
+				return lang, true
+			}
+		}
+		if lang, ok := languages[candidate]; ok {
+			return lang, true
+		}
+	}
+	if len(code) == 3 && strings.Trim(code, "abcdefghijklmnopqrstuvwxyz") == "" {
+		return Language{Code: code}, true
+	}
+	return Language{}, false
+}
+
+var languages = map[string]Language{}
+
+// shortLanguageCodes maps the ISO-639-2 codes of the built-in table to their
+// ISO-639-1 codes, as preferred by the language tags of RFC 5646.
+var shortLanguageCodes = map[string]string{}
+
+func init() {
+	for _, lang := range []struct {
+		codes []string
+		Language
+	}{
+		{[]string{"ar", "ara"}, Language{"ara", "Arabic"}},
+		{[]string{"bn", "ben"}, Language{"ben", "Bengali"}},
+		{[]string{"ca", "cat"}, Language{"cat", "Catalan"}},
+		{[]string{"cs", "ces", "cze"}, Language{"ces", "Czech"}},
+		{[]string{"da", "dan"}, Language{"dan", "Danish"}},
+		{[]string{"de", "deu", "ger"}, Language{"deu", "German"}},
+		{[]string{"el", "ell", "gre"}, Language{"ell", "Greek"}},
+		{[]string{"en", "eng"}, Language{"eng", "English"}},
+		{[]string{"es", "spa"}, Language{"spa", "Spanish"}},
+		{[]string{"fa", "fas", "per"}, Language{"fas", "Persian"}},
+		{[]string{"fi", "fin"}, Language{"fin", "Finnish"}},
+		{[]string{"fr", "fra", "fre"}, Language{"fra", "French"}},
+		{[]string{"he", "heb"}, Language{"heb", "Hebrew"}},
+		{[]string{"hi", "hin"}, Language{"hin", "Hindi"}},
+		{[]string{"hu", "hun"}, Language{"hun", "Hungarian"}},
+		{[]string{"id", "ind"}, Language{"ind", "Indonesian"}},
+		{[]string{"it", "ita"}, Language{"ita", "Italian"}},
+		{[]string{"ja", "jpn"}, Language{"jpn", "Japanese"}},
+		{[]string{"ko", "kor"}, Language{"kor", "Korean"}},
+		{[]string{"ms", "msa", "may"}, Language{"msa", "Malay"}},
+		{[]string{"nl", "nld", "dut"}, Language{"nld", "Dutch"}},
+		{[]string{"no", "nor"}, Language{"nor", "Norwegian"}},
+		{[]string{"pl", "pol"}, Language{"pol", "Polish"}},
+		{[]string{"pt", "por"}, Language{"por", "Portuguese"}},
+		{[]string{"ro", "ron", "rum"}, Language{"ron", "Romanian"}},
+		{[]string{"ru", "rus"}, Language{"rus", "Russian"}},
+		{[]string{"sv", "swe"}, Language{"swe", "Swedish"}},
+		{[]string{"th", "tha"}, Language{"tha", "Thai"}},
+		{[]string{"tl", "tgl"}, Language{"tgl", "Tagalog"}},
+		{[]string{"tr", "tur"}, Language{"tur", "Turkish"}},
+		{[]string{"uk", "ukr"}, Language{"ukr", "Ukrainian"}},
+		{[]string{"vi", "vie"}, Language{"vie", "Vietnamese"}},
+		{[]string{"zh", "zho", "chi"}, Language{"zho", "Chinese"}},
+	} {
+		for _, code := range lang.codes {
+			languages[code] = lang.Language
+		}
+		if len(lang.codes[0]) == 2 {
+			shortLanguageCodes[lang.Code] = lang.codes[0]
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package vodmodule
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompileNamingTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		input    string
+		expected map[string]string
+	}{
+		{
+			"{id}_{*}_caption_{lang}_{*}.vtt",
+			"77071_1_caption_wg_240p_001f8ea7-749b-4d43-7bd5-b357e4e24f32.vtt",
+			map[string]string{"id": "77071", "lang": "wg"},
+		},
+		{
+			"{id}_{*}_caption_{lang}_{*}.vtt",
+			"77071_1_caption_wg_240p_001f8ea7-749b-4d43-7bd5-b357e4e24f32.srt",
+			nil,
+		},
+		{
+			"subs_{lang}.{label}.vtt",
+			"subs_pt-br.Português (Brasil).vtt",
+			map[string]string{"lang": "pt-br", "label": "Português (Brasil)"},
+		},
+	}
+	for _, test := range tests {
+		pattern, err := CompileNamingTemplate(test.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := NamingConvention{Pattern: pattern}
+		got := n.captures(test.input)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%q on %q: wrong captures\nwant %#v\ngot  %#v", test.template, test.input, test.expected, got)
+		}
+	}
+}
+
+func TestCompileNamingTemplateInvalid(t *testing.T) {
+	_, err := CompileNamingTemplate("{id}_caption_{lang")
+	if err == nil {
+		t.Error("unexpected <nil> error")
+	}
+}
+
+func TestNamingConventionApply(t *testing.T) {
+	pattern, err := CompileNamingTemplate("{id}_{width}x{height}_{bitrate}k.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &NamingConvention{Pattern: pattern}
+	const filename = "show_1280x720_2500k.mp4"
+	var seq Sequence
+	n.apply(&seq, filename, n.captures(filename))
+	expected := Sequence{ID: "show", Bitrate: &Bitrate{Video: 2500000}, Width: 1280, Height: 720}
+	if !reflect.DeepEqual(seq, expected) {
+		t.Errorf("wrong sequence\nwant %#v\ngot  %#v", expected, seq)
+	}
+}
+
+func TestLookupLanguage(t *testing.T) {
+	n := &NamingConvention{Languages: map[string]Language{"wg": {Code: "eng", Label: "English (Well)"}}}
+	tests := []struct {
+		code     string
+		expected Language
+		ok       bool
+	}{
+		{"en", Language{"eng", "English"}, true},
+		{"ES", Language{"spa", "Spanish"}, true},
+		{"fre", Language{"fra", "French"}, true},
+		{"pt-BR", Language{"por", "Portuguese"}, true},
+		{"wg", Language{"eng", "English (Well)"}, true},
+		{"yid", Language{Code: "yid"}, true},
+		{"xx", Language{}, false},
+	}
+	for _, test := range tests {
+		lang, ok := n.LookupLanguage(test.code)
+		if lang != test.expected || ok != test.ok {
+			t.Errorf("LookupLanguage(%q): want (%#v, %v), got (%#v, %v)", test.code, test.expected, test.ok, lang, ok)
+		}
+	}
+}
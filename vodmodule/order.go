@@ -0,0 +1,129 @@
+package vodmodule
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sort modes supported by Ordering. Any other value is handled as the name of
+// a numeric group captured by the naming convention, like "height" or
+// "bitrate".
+const (
+	SortLexical = "lexical"
+	SortNatural = "natural"
+)
+
+// captionExtensions lists the extensions of subtitle files supported by
+// nginx-vod-module.
+var captionExtensions = map[string]bool{
+	".dfxp": true,
+	".srt":  true,
+	".ttml": true,
+	".vtt":  true,
+}
+
+// Ordering defines how sequences are sorted in the mapping.
+type Ordering struct {
+	// By is the sort mode: SortLexical (the default), SortNatural, or the
+	// name of a captured group with numeric values. Sequences without the
+	// group are sorted after the other ones, in natural order.
+	By string
+
+	// Descending reverses the order defined by By.
+	Descending bool
+
+	// MediaFirst places audio and video sequences before captions,
+	// regardless of By.
+	MediaFirst bool
+}
+
+// entry is a sequence along with the data used to sort and match it.
+type entry struct {
+	seq      Sequence
+	name     string
+	captures map[string]string
+
+	// durations of the clips in the sequence, in milliseconds (zero when
+	// unknown).
+	durations []int64
+}
+
+func (o Ordering) sort(entries []entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if o.MediaFirst {
+			if ca, cb := isCaption(a.name), isCaption(b.name); ca != cb {
+				return cb
+			}
+		}
+		switch o.By {
+		case "", SortLexical:
+			return o.less(a.name < b.name, b.name < a.name)
+		case SortNatural:
+			return o.less(naturalLess(a.name, b.name), naturalLess(b.name, a.name))
+		}
+		va, errA := strconv.ParseFloat(a.captures[o.By], 64)
+		vb, errB := strconv.ParseFloat(b.captures[o.By], 64)
+		switch {
+		case errA != nil && errB != nil:
+			return naturalLess(a.name, b.name)
+		case errA != nil || errB != nil:
+			return errB != nil
+		}
+		return o.less(va < vb, vb < va)
+	})
+}
+
+// less returns the result of the comparison given the results of both a < b
+// and b < a, taking the direction of the ordering into account.
+func (o Ordering) less(ab, ba bool) bool {
+	if o.Descending {
+		return ba
+	}
+	return ab
+}
+
+func isCaption(name string) bool {
+	return captionExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// naturalLess compares strings handling sequences of digits as numbers, so
+// "video_240p" sorts before "video_1080p".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		ca, cb := chunk(a), chunk(b)
+		a, b = a[len(ca):], b[len(cb):]
+		if ca == cb {
+			continue
+		}
+		if isDigit(ca[0]) && isDigit(cb[0]) {
+			na, nb := strings.TrimLeft(ca, "0"), strings.TrimLeft(cb, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			return len(ca) < len(cb)
+		}
+		return ca < cb
+	}
+	return len(a) < len(b)
+}
+
+// chunk returns the leading run of either digits or non-digits in s.
+func chunk(s string) string {
+	digit := isDigit(s[0])
+	for i := 1; i < len(s); i++ {
+		if isDigit(s[i]) != digit {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
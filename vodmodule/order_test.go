@@ -0,0 +1,93 @@
+package vodmodule
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"video_240p.mp4", "video_1080p.mp4", true},
+		{"video_1080p.mp4", "video_240p.mp4", false},
+		{"part2.mp4", "part10.mp4", true},
+		{"part02.mp4", "part2.mp4", false},
+		{"part2.mp4", "part02.mp4", true},
+		{"a.mp4", "b.mp4", true},
+		{"video", "video_240p", true},
+		{"video", "video", false},
+	}
+	for _, test := range tests {
+		if got := naturalLess(test.a, test.b); got != test.expected {
+			t.Errorf("naturalLess(%q, %q): want %v, got %v", test.a, test.b, test.expected, got)
+		}
+	}
+}
+
+func TestOrderingSort(t *testing.T) {
+	names := []string{
+		"video_1080p.mp4",
+		"video_240p.mp4",
+		"captions_en.vtt",
+		"audio.m4a",
+		"video_720p.mp4",
+	}
+	heights := map[string]string{
+		"video_1080p.mp4": "1080",
+		"video_240p.mp4":  "240",
+		"video_720p.mp4":  "720",
+	}
+	tests := []struct {
+		name     string
+		order    Ordering
+		expected []string
+	}{
+		{
+			"lexical",
+			Ordering{},
+			[]string{"audio.m4a", "captions_en.vtt", "video_1080p.mp4", "video_240p.mp4", "video_720p.mp4"},
+		},
+		{
+			"natural descending",
+			Ordering{By: SortNatural, Descending: true},
+			[]string{"video_1080p.mp4", "video_720p.mp4", "video_240p.mp4", "captions_en.vtt", "audio.m4a"},
+		},
+		{
+			"captured group",
+			Ordering{By: "height"},
+			[]string{"video_240p.mp4", "video_720p.mp4", "video_1080p.mp4", "audio.m4a", "captions_en.vtt"},
+		},
+		{
+			"captured group descending",
+			Ordering{By: "height", Descending: true},
+			[]string{"video_1080p.mp4", "video_720p.mp4", "video_240p.mp4", "audio.m4a", "captions_en.vtt"},
+		},
+		{
+			"media first",
+			Ordering{MediaFirst: true},
+			[]string{"audio.m4a", "video_1080p.mp4", "video_240p.mp4", "video_720p.mp4", "captions_en.vtt"},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			entries := make([]entry, len(names))
+			for i, name := range names {
+				entries[i] = entry{name: name}
+				if height, ok := heights[name]; ok {
+					entries[i].captures = map[string]string{"height": height}
+				}
+			}
+			test.order.sort(entries)
+			got := make([]string, len(entries))
+			for i, e := range entries {
+				got[i] = e.name
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("wrong order\nwant %q\ngot  %q", test.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package vodmodule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule/mp4"
+)
+
+// Part modes supported by MapParts.
+const (
+	// PartModeClips maps each part as a clip of every sequence, with the
+	// durations of the clips in Mapping.Durations.
+	PartModeClips = "clips"
+
+	// PartModeConcat maps each sequence as a single concat clip.
+	PartModeConcat = "concat"
+)
+
+var (
+	// ErrEmptyPart is returned by MapParts when no files are found for one
+	// of the parts.
+	ErrEmptyPart = errors.New("no files found")
+
+	// ErrMissingDuration is returned by MapParts when the duration of a
+	// part can't be determined by probing or from the metadata of the
+	// objects.
+	ErrMissingDuration = errors.New("missing duration")
+
+	// ErrNoRenditions is returned by MapParts when no rendition is found
+	// in all parts.
+	ErrNoRenditions = errors.New("vodmodule: no renditions found in all parts")
+)
+
+// MapParts maps an ordered list of parts (like a pre-roll, the content and a
+// post-roll) into a single stream. Each part is a prefix, or the name of an
+// object.
+//
+// The part with the most sequences defines the renditions of the stream,
+// which are matched in the other parts by the "rendition" captured group
+// (when set), or by the kind of media, the language and the closest height.
+// Captions only match captions in the same language. Renditions that can't be
+// matched in every part are dropped.
+//
+// Durations come from probing (see MapOptions.Prober) or from the "duration"
+// metadata of the objects, in milliseconds. Prefix, Prefixes and Manifest are
+// ignored.
+func (m *Mapper) MapParts(ctx context.Context, parts []string, mode string, opts MapOptions) (Mapping, error) {
+	switch mode {
+	case "", PartModeClips, PartModeConcat:
+	default:
+		return Mapping{}, fmt.Errorf("vodmodule: invalid part mode %q", mode)
+	}
+	if len(parts) == 0 {
+		return Mapping{}, errors.New("vodmodule: no parts to map")
+	}
+
+	partEntries := make([][]entry, len(parts))
+	ref := 0
+	for i, part := range parts {
+		partOpts := opts
+		partOpts.Prefix, partOpts.Prefixes, partOpts.Manifest = part, nil, ""
+		entries, err := m.getEntries(ctx, partOpts)
+		if err != nil {
+			return Mapping{}, err
+		}
+		for _, e := range entries {
+			if e.name == part {
+				entries = []entry{e}
+				break
+			}
+		}
+		if len(entries) == 0 {
+			return Mapping{}, fmt.Errorf("vodmodule: part %q: %w", part, ErrEmptyPart)
+		}
+		partEntries[i] = entries
+		if len(entries) > len(partEntries[ref]) {
+			ref = i
+		}
+	}
+
+	var (
+		seqs      []Sequence
+		durations [][]int64
+	)
+	for _, refEntry := range partEntries[ref] {
+		seq := refEntry.seq
+		seq.Clips = nil
+		var seqDurations []int64
+		matched := true
+		for i, entries := range partEntries {
+			e := refEntry
+			if i != ref {
+				e, matched = matchRendition(refEntry, entries)
+				if !matched {
+					break
+				}
+			}
+			seq.Clips = append(seq.Clips, e.seq.Clips...)
+			seqDurations = append(seqDurations, e.durations...)
+		}
+		if matched {
+			seqs = append(seqs, seq)
+			durations = append(durations, seqDurations)
+		}
+	}
+	if len(seqs) == 0 {
+		return Mapping{}, ErrNoRenditions
+	}
+
+	shortest := shortestDurations(durations)
+	if shortest == nil {
+		for i, d := range durations[0] {
+			if d == 0 {
+				return Mapping{}, fmt.Errorf("vodmodule: clip %q: %w", seqs[0].Clips[i].Path, ErrMissingDuration)
+			}
+		}
+		return Mapping{}, errors.New("vodmodule: parts have a different number of clips in each rendition")
+	}
+
+	if mode == PartModeConcat {
+		for i := range seqs {
+			concat := Clip{Type: ClipTypeConcat, Durations: shortest}
+			for _, clip := range seqs[i].Clips {
+				concat.Paths = append(concat.Paths, clip.Path)
+			}
+			seqs[i].Clips = []Clip{concat}
+		}
+		return Mapping{Sequences: seqs}, nil
+	}
+	return Mapping{Durations: shortest, Sequences: seqs}, nil
+}
+
+// rendition describes the media of an entry, for matching it across parts.
+type rendition struct {
+	key    string
+	kind   string
+	lang   string
+	height int
+}
+
+func renditionOf(e entry) rendition {
+	r := rendition{key: e.captures["rendition"], lang: e.seq.Language}
+	r.height, _ = strconv.Atoi(e.captures["height"])
+	switch info := e.seq.Media; {
+	case info != nil && info.Track(mp4.KindVideo) != nil:
+		r.kind = mp4.KindVideo
+		if h := info.Track(mp4.KindVideo).Height; h > 0 {
+			r.height = h
+		}
+	case info != nil && info.Track(mp4.KindAudio) != nil:
+		r.kind = mp4.KindAudio
+	case isCaption(e.name):
+		r.kind = mp4.KindText
+	case audioExtensions[strings.ToLower(path.Ext(e.name))]:
+		r.kind = mp4.KindAudio
+	default:
+		r.kind = mp4.KindVideo
+	}
+	return r
+}
+
+// matchRendition returns the entry that best matches ref among candidates.
+func matchRendition(ref entry, candidates []entry) (entry, bool) {
+	want := renditionOf(ref)
+	var (
+		best      entry
+		bestScore = -1
+	)
+	for _, candidate := range candidates {
+		got := renditionOf(candidate)
+		if want.key != "" || got.key != "" {
+			if want.key == got.key {
+				return candidate, true
+			}
+			continue
+		}
+		if got.kind != want.kind || (got.kind == mp4.KindText && got.lang != want.lang) {
+			continue
+		}
+		// prefer the same language, then the closest height.
+		score := 1 << 20
+		if got.lang != want.lang {
+			score = 0
+		}
+		diff := got.height - want.height
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff < 1<<20 {
+			score += 1<<20 - 1 - diff
+		}
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best, bestScore >= 0
+}
@@ -0,0 +1,129 @@
+package vodmodule
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMapParts(t *testing.T) {
+	server, bucket := fakeBucketHandle(t, "my-bucket")
+	defer server.Stop()
+	mapper := NewBucketMapper(&memBucket{Bucket: GCSBucket(bucket)})
+	parts := []string{"ads/preroll/", "videos/probed/clip1_", "ads/postroll/bumper.mp4"}
+
+	tests := []struct {
+		mode              string
+		expectedDurations []int64
+		expectedClips     [][]Clip
+	}{
+		{
+			PartModeClips,
+			[]int64{5000, 10000, 3000},
+			[][]Clip{
+				{
+					{Type: "source", Path: "/my-bucket/ads/preroll/ad_480p.mp4"},
+					{Type: "source", Path: "/my-bucket/videos/probed/clip1_480p.mp4"},
+					{Type: "source", Path: "/my-bucket/ads/postroll/bumper.mp4"},
+				},
+				{
+					{Type: "source", Path: "/my-bucket/ads/preroll/ad_720p.mp4"},
+					{Type: "source", Path: "/my-bucket/videos/probed/clip1_720p.mp4"},
+					{Type: "source", Path: "/my-bucket/ads/postroll/bumper.mp4"},
+				},
+			},
+		},
+		{
+			PartModeConcat,
+			nil,
+			[][]Clip{
+				{
+					{
+						Type: "concat",
+						Paths: []string{
+							"/my-bucket/ads/preroll/ad_480p.mp4",
+							"/my-bucket/videos/probed/clip1_480p.mp4",
+							"/my-bucket/ads/postroll/bumper.mp4",
+						},
+						Durations: []int64{5000, 10000, 3000},
+					},
+				},
+				{
+					{
+						Type: "concat",
+						Paths: []string{
+							"/my-bucket/ads/preroll/ad_720p.mp4",
+							"/my-bucket/videos/probed/clip1_720p.mp4",
+							"/my-bucket/ads/postroll/bumper.mp4",
+						},
+						Durations: []int64{5000, 10000, 3000},
+					},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.mode, func(t *testing.T) {
+			m, err := mapper.MapParts(context.TODO(), parts, test.mode, MapOptions{Prober: NewProber(0)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m.Durations, test.expectedDurations) {
+				t.Errorf("wrong durations\nwant %v\ngot  %v", test.expectedDurations, m.Durations)
+			}
+			var clips [][]Clip
+			for _, seq := range m.Sequences {
+				clips = append(clips, seq.Clips)
+			}
+			if !reflect.DeepEqual(clips, test.expectedClips) {
+				t.Errorf("wrong clips\nwant %#v\ngot  %#v", test.expectedClips, clips)
+			}
+			if err := m.Validate(); err != nil {
+				t.Errorf("invalid mapping: %v", err)
+			}
+		})
+	}
+}
+
+func TestMapPartsMetadataDuration(t *testing.T) {
+	server, bucket := fakeBucketHandle(t, "my-bucket")
+	defer server.Stop()
+	mapper := NewBucketMapper(&memBucket{Bucket: GCSBucket(bucket)})
+	parts := []string{"videos/probed/clip2_480p.mp4", "ads/postroll/slate.mp4"}
+
+	_, err := mapper.MapParts(context.TODO(), parts, PartModeClips, MapOptions{})
+	if !errors.Is(err, ErrMissingDuration) {
+		t.Errorf("wrong error returned\nwant %v\ngot  %v", ErrMissingDuration, err)
+	}
+
+	m, err := mapper.MapParts(context.TODO(), parts, PartModeClips, MapOptions{Prober: NewProber(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int64{5000, 2000}; !reflect.DeepEqual(m.Durations, expected) {
+		t.Errorf("wrong durations\nwant %v\ngot  %v", expected, m.Durations)
+	}
+}
+
+func TestMapPartsErrors(t *testing.T) {
+	server, bucket := fakeBucketHandle(t, "my-bucket")
+	defer server.Stop()
+	mapper := NewBucketMapper(&memBucket{Bucket: GCSBucket(bucket)})
+
+	_, err := mapper.MapParts(context.TODO(), []string{"ads/preroll/", "ads/midroll/"}, PartModeClips, MapOptions{})
+	if !errors.Is(err, ErrEmptyPart) {
+		t.Errorf("wrong error returned\nwant %v\ngot  %v", ErrEmptyPart, err)
+	}
+
+	_, err = mapper.MapParts(context.TODO(), []string{"ads/preroll/ad_en.vtt", "musics/music/music4.mp3"}, PartModeClips, MapOptions{})
+	if err != ErrNoRenditions {
+		t.Errorf("wrong error returned\nwant %v\ngot  %v", ErrNoRenditions, err)
+	}
+
+	_, err = mapper.MapParts(context.TODO(), []string{"ads/preroll/"}, "playlist", MapOptions{})
+	if err == nil {
+		t.Error("unexpected <nil> error for invalid mode")
+	}
+}
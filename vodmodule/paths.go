@@ -0,0 +1,194 @@
+package vodmodule
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// DefaultClipPath is the template used for clip paths when none is given,
+// which works with the proxy mode when the bucket is in the path.
+const DefaultClipPath = "/{bucket}/{object}"
+
+// DefaultTokenTTL is the lifetime of tokens when TokenSigner.TTL isn't set.
+const DefaultTokenTTL = time.Hour
+
+// ErrInvalidToken is returned by TokenSigner.Verify for tokens that are
+// malformed, expired or signed with another secret.
+var ErrInvalidToken = errors.New("vodmodule: invalid token")
+
+var placeholderRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+
+// ClipPathTemplate builds the paths of source clips from the attributes of
+// objects. Template supports the following placeholders:
+//
+//   - {bucket}: the name of the bucket
+//   - {object}: the name of the object
+//   - {generation}: the generation of the object
+//   - {proxy}: the value of Proxy, like the prefix of the proxy mode
+//   - {token}: a token signed by Signer for the bucket and the object
+//
+// For example, "https://media.example.com/{object}?token={token}" produces
+// URLs for remote upstreams, and "/mnt/{bucket}/{object}" produces local
+// paths. In templates served by the proxy or by remote upstreams (the
+// default, templates that start with {proxy} and http(s) URLs), each segment
+// of {bucket} and {object} is escaped, so names with characters like "#" or
+// "?" don't break the URL. Local paths are kept as is.
+//
+// When PinGeneration is set, paths of objects with a generation get the
+// "generation" query string parameter (unless the template already uses
+// {generation}), so the proxy serves that exact generation even after the
+// object is replaced. The parameter is only added to paths served by the
+// proxy, so the template must be the default, start with {proxy} or be an
+// http(s) URL.
+type ClipPathTemplate struct {
+	Template      string
+	Proxy         string
+	Signer        *TokenSigner
+	PinGeneration bool
+}
+
+// Validate checks that the template only uses known placeholders, that a
+// signer is configured when tokens are used, and that pinned generations can
+// be added to the template.
+func (t *ClipPathTemplate) Validate() error {
+	if t.PinGeneration && !strings.Contains(t.Template, "{generation}") && !t.proxied() {
+		return fmt.Errorf("invalid clip path template %q: pinning generations requires a proxy path or an http(s) URL", t.Template)
+	}
+	for _, placeholder := range placeholderRegexp.FindAllString(t.Template, -1) {
+		switch placeholder {
+		case "{bucket}", "{object}", "{generation}", "{proxy}":
+		case "{token}":
+			if t.Signer == nil {
+				return fmt.Errorf("invalid clip path template %q: {token} requires a signer", t.Template)
+			}
+		default:
+			return fmt.Errorf("invalid clip path template %q: unknown placeholder %s", t.Template, placeholder)
+		}
+	}
+	return nil
+}
+
+// path returns the path of the clip that refers to the given object.
+func (t *ClipPathTemplate) path(obj *storage.ObjectAttrs) string {
+	if t == nil {
+		return "/" + escapePath(obj.Bucket) + "/" + escapePath(obj.Name)
+	}
+	template := t.Template
+	if template == "" {
+		template = DefaultClipPath
+	}
+	if t.PinGeneration && obj.Generation > 0 && !strings.Contains(template, "{generation}") {
+		separator := "?"
+		if strings.Contains(template, "?") {
+			separator = "&"
+		}
+		template += separator + "generation={generation}"
+	}
+	escape := func(name string) string { return name }
+	if t.proxied() {
+		escape = escapePath
+	}
+	return placeholderRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		switch placeholder {
+		case "{bucket}":
+			return escape(obj.Bucket)
+		case "{object}":
+			return escape(obj.Name)
+		case "{generation}":
+			return strconv.FormatInt(obj.Generation, 10)
+		case "{proxy}":
+			return t.Proxy
+		case "{token}":
+			if t.Signer != nil {
+				return t.Signer.Sign(obj.Bucket, obj.Name)
+			}
+		}
+		return placeholder
+	})
+}
+
+// proxied reports whether the template refers to the proxy mode or to a
+// remote URL, rather than to local files.
+func (t *ClipPathTemplate) proxied() bool {
+	return t.Template == "" || t.Template == DefaultClipPath ||
+		strings.HasPrefix(t.Template, "{proxy}") || isURL(t.Template)
+}
+
+// isURL reports whether the template is an absolute http(s) URL.
+func isURL(template string) bool {
+	lower := strings.ToLower(template)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// escapePath escapes each segment of the slash-separated name.
+func escapePath(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// TokenSigner signs tokens that grant access to an object until they expire.
+//
+// Tokens are in the format "<expires>-<signature>", where expires is a Unix
+// timestamp and signature is the base64url-encoded (without padding)
+// HMAC-SHA256 of "<bucket>/<object>:<expires>" using Secret.
+type TokenSigner struct {
+	Secret []byte
+
+	// TTL is the lifetime of tokens. Defaults to DefaultTokenTTL.
+	TTL time.Duration
+
+	now func() time.Time
+}
+
+// Sign returns a token for the given object.
+func (s *TokenSigner) Sign(bucket, object string) string {
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = DefaultTokenTTL
+	}
+	expires := s.clock().Add(ttl).Unix()
+	return strconv.FormatInt(expires, 10) + "-" + s.signature(bucket, object, expires)
+}
+
+// Verify checks that the token was signed for the given object and hasn't
+// expired.
+func (s *TokenSigner) Verify(bucket, object, token string) error {
+	parts := strings.SplitN(token, "-", 2)
+	if len(parts) != 2 {
+		return ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || s.clock().Unix() > expires {
+		return ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(s.signature(bucket, object, expires))) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func (s *TokenSigner) signature(bucket, object string, expires int64) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(bucket + "/" + object + ":" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *TokenSigner) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,173 @@
+package vodmodule
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestClipPathTemplate(t *testing.T) {
+	now := time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC)
+	signer := &TokenSigner{Secret: []byte("secret"), TTL: time.Minute, now: func() time.Time { return now }}
+	obj := &storage.ObjectAttrs{Bucket: "my-bucket", Name: "videos/video1_480p.mp4", Generation: 1583841600000000}
+	tests := []struct {
+		name     string
+		template *ClipPathTemplate
+		expected string
+	}{
+		{"default", nil, "/my-bucket/videos/video1_480p.mp4"},
+		{"empty", &ClipPathTemplate{}, "/my-bucket/videos/video1_480p.mp4"},
+		{"proxy", &ClipPathTemplate{Template: "{proxy}{bucket}/{object}", Proxy: "/proxy/"}, "/proxy/my-bucket/videos/video1_480p.mp4"},
+		{"local", &ClipPathTemplate{Template: "/mnt/media/{object}"}, "/mnt/media/videos/video1_480p.mp4"},
+		{
+			"remote with generation",
+			&ClipPathTemplate{Template: "https://storage.googleapis.com/{bucket}/{object}?generation={generation}"},
+			"https://storage.googleapis.com/my-bucket/videos/video1_480p.mp4?generation=1583841600000000",
+		},
+		{"pinned default", &ClipPathTemplate{PinGeneration: true}, "/my-bucket/videos/video1_480p.mp4?generation=1583841600000000"},
+		{
+			"pinned with query string",
+			&ClipPathTemplate{Template: "{proxy}{object}?cache=1", Proxy: "/proxy/", PinGeneration: true},
+			"/proxy/videos/video1_480p.mp4?cache=1&generation=1583841600000000",
+		},
+		{
+			"pinned with generation placeholder",
+			&ClipPathTemplate{Template: "/{bucket}/{object}#{generation}", PinGeneration: true},
+			"/my-bucket/videos/video1_480p.mp4#1583841600000000",
+		},
+		{
+			"token",
+			&ClipPathTemplate{Template: "https://cdn.example.com/{object}?token={token}", Signer: signer},
+			"https://cdn.example.com/videos/video1_480p.mp4?token=" + signer.Sign("my-bucket", "videos/video1_480p.mp4"),
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if test.template != nil {
+				if err := test.template.Validate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := test.template.path(obj); got != test.expected {
+				t.Errorf("wrong path\nwant %q\ngot  %q", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestClipPathTemplateEscaping(t *testing.T) {
+	now := time.Unix(1583841600, 0)
+	signer := &TokenSigner{Secret: []byte("secret"), now: func() time.Time { return now }}
+	obj := &storage.ObjectAttrs{Bucket: "my-bucket", Name: "videos/my show #1?.mp4", Generation: 42}
+	tests := []struct {
+		name     string
+		template *ClipPathTemplate
+		expected string
+	}{
+		{
+			"url",
+			&ClipPathTemplate{Template: "https://cdn.example.com/{bucket}/{object}?token={token}", Signer: signer},
+			"https://cdn.example.com/my-bucket/videos/my%20show%20%231%3F.mp4?token=" + signer.Sign("my-bucket", "videos/my show #1?.mp4"),
+		},
+		{
+			"default template",
+			&ClipPathTemplate{PinGeneration: true, Signer: signer},
+			"/my-bucket/videos/my%20show%20%231%3F.mp4?generation=42",
+		},
+		{
+			"proxy",
+			&ClipPathTemplate{Template: "{proxy}/{bucket}/{object}?token={token}", Proxy: "/proxy", PinGeneration: true, Signer: signer},
+			"/proxy/my-bucket/videos/my%20show%20%231%3F.mp4?token=" + signer.Sign("my-bucket", "videos/my show #1?.mp4") + "&generation=42",
+		},
+		{"nil template", nil, "/my-bucket/videos/my%20show%20%231%3F.mp4"},
+		{"local path", &ClipPathTemplate{Template: "/mnt/{bucket}/{object}"}, "/mnt/my-bucket/videos/my show #1?.mp4"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.template.path(obj); got != test.expected {
+				t.Errorf("wrong path\nwant %q\ngot  %q", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestClipPathTemplateWithoutGeneration(t *testing.T) {
+	obj := &storage.ObjectAttrs{Bucket: "my-bucket", Name: "videos/video1_480p.mp4"}
+	template := &ClipPathTemplate{PinGeneration: true}
+	if got, expected := template.path(obj), "/my-bucket/videos/video1_480p.mp4"; got != expected {
+		t.Errorf("wrong path\nwant %q\ngot  %q", expected, got)
+	}
+}
+
+func TestClipPathTemplateValidate(t *testing.T) {
+	tests := []*ClipPathTemplate{
+		{Template: "/{bucket}/{name}"},
+		{Template: "{proxy}{object}?t={token}"},
+		{Template: "/mnt/{bucket}/{object}", PinGeneration: true},
+	}
+	for _, template := range tests {
+		if err := template.Validate(); err == nil {
+			t.Errorf("%#v: unexpected <nil> error", template)
+		}
+	}
+}
+
+func TestTokenSigner(t *testing.T) {
+	now := time.Unix(1583841600, 0)
+	signer := &TokenSigner{Secret: []byte("secret"), now: func() time.Time { return now }}
+	token := signer.Sign("my-bucket", "videos/video1_480p.mp4")
+	if !strings.HasPrefix(token, "1583845200-") {
+		t.Errorf("wrong expiration in token %q", token)
+	}
+	if err := signer.Verify("my-bucket", "videos/video1_480p.mp4", token); err != nil {
+		t.Errorf("unexpected error verifying token: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		signer *TokenSigner
+		object string
+		token  string
+	}{
+		{"other object", signer, "videos/video1_720p.mp4", token},
+		{"other secret", &TokenSigner{Secret: []byte("other"), now: signer.now}, "videos/video1_480p.mp4", token},
+		{"expired", &TokenSigner{Secret: []byte("secret"), now: func() time.Time { return now.Add(2 * time.Hour) }}, "videos/video1_480p.mp4", token},
+		{"malformed", signer, "videos/video1_480p.mp4", "abc"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.signer.Verify("my-bucket", test.object, test.token); err != ErrInvalidToken {
+				t.Errorf("wrong error returned\nwant %v\ngot  %v", ErrInvalidToken, err)
+			}
+		})
+	}
+}
+
+func TestMapClipPath(t *testing.T) {
+	server, bucket := fakeBucketHandle(t, "my-bucket")
+	defer server.Stop()
+	mapper := NewBucketMapper(&memBucket{Bucket: GCSBucket(bucket)})
+	m, err := mapper.Map(context.TODO(), MapOptions{
+		Prefix:   "videos/probed/clip2_",
+		ClipPath: &ClipPathTemplate{Template: "{proxy}{bucket}/{object}", Proxy: "/proxy/"},
+		Prober:   NewProber(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/proxy/my-bucket/videos/probed/clip2_480p.mp4", "/proxy/my-bucket/videos/probed/clip2_720p.mp4"}
+	for i, seq := range m.Sequences {
+		if seq.Clips[0].Path != expected[i] {
+			t.Errorf("wrong path in sequence %d\nwant %q\ngot  %q", i, expected[i], seq.Clips[0].Path)
+		}
+		if seq.Media == nil {
+			t.Errorf("sequence %d wasn't probed", i)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package vodmodule
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule/mp4"
+)
+
+const (
+	defaultProbeCacheSize = 10000
+	probeConcurrency      = 8
+)
+
+// probeExtensions lists the extensions of files that are probed as MP4.
+var probeExtensions = map[string]bool{
+	".m4a": true,
+	".m4v": true,
+	".mov": true,
+	".mp4": true,
+}
+
+// Prober reads the metadata of MP4 files using ranged reads of the listed
+// generation, caching the results by object generation. It's safe for
+// concurrent use.
+type Prober struct {
+	maxEntries int
+
+	mu    sync.Mutex
+	cache map[probeKey]*mp4.Info
+}
+
+type probeKey struct {
+	bucket     string
+	name       string
+	generation int64
+	etag       string
+}
+
+// NewProber returns a prober that caches up to maxEntries results (10000
+// when maxEntries is zero or negative).
+func NewProber(maxEntries int) *Prober {
+	if maxEntries <= 0 {
+		maxEntries = defaultProbeCacheSize
+	}
+	return &Prober{maxEntries: maxEntries, cache: map[probeKey]*mp4.Info{}}
+}
+
+// Probe returns the metadata of the given object, which is read from bucket
+// unless it's already cached.
+func (p *Prober) Probe(ctx context.Context, bucket Bucket, obj *storage.ObjectAttrs) (*mp4.Info, error) {
+	key := probeKey{bucket: obj.Bucket, name: obj.Name, generation: obj.Generation, etag: obj.Etag}
+	p.mu.Lock()
+	info, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+	r := &objectReaderAt{ctx: ctx, bucket: bucket, object: obj.Name, generation: obj.Generation, size: obj.Size}
+	info, err := mp4.Parse(r, obj.Size)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.cache) >= p.maxEntries {
+		// evict an arbitrary entry, as map iteration order is random.
+		for k := range p.cache {
+			delete(p.cache, k)
+			break
+		}
+	}
+	p.cache[key] = info
+	return info, nil
+}
+
+// Warm probes the object if it looks like an MP4 file, so mappings that
+// include it find the result in the cache.
+func (p *Prober) Warm(ctx context.Context, bucket Bucket, obj *storage.ObjectAttrs) error {
+	if !probeExtensions[strings.ToLower(path.Ext(obj.Name))] {
+		return nil
+	}
+	_, err := p.Probe(ctx, bucket, obj)
+	return err
+}
+
+// Invalidate removes the cached results of all generations of the object,
+// returning the number of removed results.
+func (p *Prober) Invalidate(bucket, name string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var removed int
+	for key := range p.cache {
+		if key.bucket == bucket && key.name == name {
+			delete(p.cache, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// probeAll probes the objects that look like MP4 files concurrently,
+// returning the results by object name. Failures are ignored, as probing is
+// optional.
+func (p *Prober) probeAll(ctx context.Context, bucket Bucket, objs []*storage.ObjectAttrs) map[string]*mp4.Info {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = map[string]*mp4.Info{}
+		sem     = make(chan struct{}, probeConcurrency)
+	)
+	for _, obj := range objs {
+		if !probeExtensions[strings.ToLower(path.Ext(obj.Name))] {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(obj *storage.ObjectAttrs) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			info, err := p.Probe(ctx, bucket, obj)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			results[obj.Name] = info
+			mu.Unlock()
+		}(obj)
+	}
+	wg.Wait()
+	return results
+}
+
+// applyProbe fills the metadata of the sequence from the probe of its first
+// clip, without replacing values extracted from the file name.
+func applyProbe(seq *Sequence, info *mp4.Info, naming *NamingConvention) {
+	seq.Media = info
+	var bitrate Bitrate
+	if video := info.Track(mp4.KindVideo); video != nil {
+		bitrate.Video = video.Bitrate()
+		if seq.Width == 0 && seq.Height == 0 {
+			seq.Width, seq.Height = video.Width, video.Height
+		}
+	}
+	if audio := info.Track(mp4.KindAudio); audio != nil {
+		bitrate.Audio = audio.Bitrate()
+		if seq.Language == "" && audio.Language != "" {
+			if lang, ok := naming.LookupLanguage(audio.Language); ok {
+				seq.Language = lang.Code
+				if seq.Label == "" {
+					seq.Label = lang.Label
+				}
+			}
+		}
+	}
+	if seq.AvgBitrate == nil && (bitrate.Video > 0 || bitrate.Audio > 0) {
+		seq.AvgBitrate = &bitrate
+	}
+}
+
+// clipDurations returns the durations of clips in milliseconds, using the
+// shortest clip at each position. It returns nil unless all sequences have the
+// same number of clips (more than one), and the duration of at least one clip
+// is known at each position.
+func clipDurations(entries []entry) []int64 {
+	if len(entries) == 0 || len(entries[0].durations) < 2 {
+		return nil
+	}
+	var all [][]int64
+	for _, e := range entries {
+		all = append(all, e.durations)
+	}
+	return shortestDurations(all)
+}
+
+// shortestDurations returns the shortest known duration at each position of
+// the given lists, or nil if the lists have different lengths or if no
+// duration is known at a position.
+func shortestDurations(lists [][]int64) []int64 {
+	durations := make([]int64, len(lists[0]))
+	for _, list := range lists {
+		if len(list) != len(durations) {
+			return nil
+		}
+		for i, d := range list {
+			if d > 0 && (durations[i] == 0 || d < durations[i]) {
+				durations[i] = d
+			}
+		}
+	}
+	for _, d := range durations {
+		if d == 0 {
+			return nil
+		}
+	}
+	return durations
+}
+
+// objectReaderAt is an io.ReaderAt that issues one ranged read per call, all
+// of them reading the same generation of the object.
+type objectReaderAt struct {
+	ctx        context.Context
+	bucket     Bucket
+	object     string
+	generation int64
+	size       int64
+}
+
+func (r *objectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	length := int64(len(p))
+	if off+length > r.size {
+		length = r.size - off
+	}
+	rc, err := r.bucket.NewRangeReader(r.ctx, r.object, r.generation, off, length)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	n, err := io.ReadFull(rc, p[:length])
+	if err == nil && length < int64(len(p)) {
+		err = io.EOF
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,214 @@
+package vodmodule
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/NYTimes/gcs-helper/v3/internal/testhelper"
+	"github.com/NYTimes/gcs-helper/v3/vodmodule/mp4"
+)
+
+// memBucket reads objects from memory, as fake-gcs-server doesn't handle
+// bounded ranges properly. Listings are delegated to the wrapped bucket.
+type memBucket struct {
+	Bucket
+	mu          sync.Mutex
+	reads       int
+	generations map[int64]bool
+}
+
+func (b *memBucket) NewRangeReader(ctx context.Context, object string, generation, offset, length int64) (io.ReadCloser, error) {
+	b.mu.Lock()
+	b.reads++
+	if b.generations == nil {
+		b.generations = map[int64]bool{}
+	}
+	b.generations[generation] = true
+	b.mu.Unlock()
+	for _, obj := range testhelper.FakeObjects {
+		if obj.BucketName == "my-bucket" && obj.Name == object {
+			content := obj.Content[offset:]
+			if length >= 0 && length < int64(len(content)) {
+				content = content[:length]
+			}
+			return ioutil.NopCloser(bytes.NewReader(content)), nil
+		}
+	}
+	return nil, storage.ErrObjectNotExist
+}
+
+func TestMapProbe(t *testing.T) {
+	server, bucket := fakeBucketHandle(t, "my-bucket")
+	defer server.Stop()
+	mapper := NewBucketMapper(&memBucket{Bucket: GCSBucket(bucket)})
+
+	m, err := mapper.Map(context.TODO(), MapOptions{
+		Prefix: "videos/probed/",
+		Naming: &NamingConvention{Pattern: regexp.MustCompile(`^clip\d+_(?P<id>(?P<group>\d+)p)\.mp4$`)},
+		Prober: NewProber(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int64{10000, 5000}; !reflect.DeepEqual(m.Durations, expected) {
+		t.Errorf("wrong durations\nwant %v\ngot  %v", expected, m.Durations)
+	}
+	if len(m.Sequences) != 2 {
+		t.Fatalf("wrong number of sequences\nwant 2\ngot  %d", len(m.Sequences))
+	}
+	expected := []struct {
+		id      string
+		height  int
+		bitrate Bitrate
+	}{
+		{"480p", 480, Bitrate{Video: 1000000, Audio: 128000}},
+		{"720p", 720, Bitrate{Video: 2500000, Audio: 128000}},
+	}
+	for i, seq := range m.Sequences {
+		if seq.ID != expected[i].id {
+			t.Errorf("wrong id\nwant %q\ngot  %q", expected[i].id, seq.ID)
+		}
+		if seq.Language != "eng" || seq.Label != "English" {
+			t.Errorf("%s: wrong language %q and label %q", seq.ID, seq.Language, seq.Label)
+		}
+		if seq.AvgBitrate == nil || *seq.AvgBitrate != expected[i].bitrate {
+			t.Errorf("%s: wrong average bitrate\nwant %#v\ngot  %#v", seq.ID, expected[i].bitrate, seq.AvgBitrate)
+		}
+		if seq.Media == nil || seq.Media.Track(mp4.KindVideo).Height != expected[i].height {
+			t.Errorf("%s: wrong media info: %#v", seq.ID, seq.Media)
+		}
+		if seq.Height != expected[i].height {
+			t.Errorf("%s: wrong height\nwant %d\ngot  %d", seq.ID, expected[i].height, seq.Height)
+		}
+	}
+}
+
+func TestMapProbeSingleClips(t *testing.T) {
+	server, bucket := fakeBucketHandle(t, "my-bucket")
+	defer server.Stop()
+	mapper := NewBucketMapper(&memBucket{Bucket: GCSBucket(bucket)})
+
+	m, err := mapper.Map(context.TODO(), MapOptions{Prefix: "videos/probed/clip1_", Prober: NewProber(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Durations != nil {
+		t.Errorf("unexpected durations for single-clip sequences: %v", m.Durations)
+	}
+	for _, seq := range m.Sequences {
+		if seq.Media == nil {
+			t.Errorf("missing media info for %s", seq.Clips[0].Path)
+		}
+	}
+}
+
+func TestMapProbeFailures(t *testing.T) {
+	server, bucket := fakeBucketHandle(t, "my-bucket")
+	defer server.Stop()
+	mapper := NewBucketMapper(&memBucket{Bucket: GCSBucket(bucket)})
+
+	// files in videos/parts/ are empty, so they can't be probed.
+	m, err := mapper.Map(context.TODO(), MapOptions{
+		Prefix: "videos/parts/",
+		Naming: &NamingConvention{Pattern: regexp.MustCompile(`_(?P<group>\d+)p`)},
+		Prober: NewProber(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Durations != nil || len(m.Sequences) != 2 || m.Sequences[0].Media != nil {
+		t.Errorf("unexpected mapping for files that can't be probed: %#v", m)
+	}
+}
+
+func TestProberCache(t *testing.T) {
+	bucket := &memBucket{}
+	prober := NewProber(1)
+	var objs []*storage.ObjectAttrs
+	for _, obj := range testhelper.FakeObjects {
+		if obj.Name == "videos/probed/clip1_480p.mp4" || obj.Name == "videos/probed/clip1_720p.mp4" {
+			objs = append(objs, &storage.ObjectAttrs{Bucket: "my-bucket", Name: obj.Name, Size: int64(len(obj.Content)), Generation: 1})
+		}
+	}
+	var reads int
+	for i := 0; i < 2; i++ {
+		info, err := prober.Probe(context.TODO(), bucket, objs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.DurationMillis() != 10000 {
+			t.Errorf("wrong duration\nwant 10000\ngot  %d", info.DurationMillis())
+		}
+		if i == 0 {
+			reads = bucket.reads
+		}
+	}
+	if reads == 0 {
+		t.Fatal("object wasn't read")
+	}
+	if bucket.reads != reads {
+		t.Errorf("cached object was read again (%d reads, want %d)", bucket.reads, reads)
+	}
+
+	objs[0].Generation = 2
+	if _, err := prober.Probe(context.TODO(), bucket, objs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if bucket.reads == reads {
+		t.Error("new generation of the object wasn't probed")
+	}
+	if expected := map[int64]bool{1: true, 2: true}; !reflect.DeepEqual(bucket.generations, expected) {
+		t.Errorf("wrong generations read\nwant %v\ngot  %v", expected, bucket.generations)
+	}
+
+	if _, err := prober.Probe(context.TODO(), bucket, objs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if len(prober.cache) != 1 {
+		t.Errorf("wrong number of cached entries\nwant 1\ngot  %d", len(prober.cache))
+	}
+}
+
+func TestProberWarmAndInvalidate(t *testing.T) {
+	bucket := &memBucket{}
+	prober := NewProber(0)
+	var obj *storage.ObjectAttrs
+	for _, fake := range testhelper.FakeObjects {
+		if fake.Name == "videos/probed/clip2_480p.mp4" {
+			obj = &storage.ObjectAttrs{Bucket: "my-bucket", Name: fake.Name, Size: int64(len(fake.Content)), Generation: 1}
+		}
+	}
+	if err := prober.Warm(context.TODO(), bucket, obj); err != nil {
+		t.Fatal(err)
+	}
+	if err := prober.Warm(context.TODO(), bucket, &storage.ObjectAttrs{Bucket: "my-bucket", Name: "videos/video/video1.vtt"}); err != nil {
+		t.Errorf("unexpected error warming non-MP4 file: %v", err)
+	}
+	reads := bucket.reads
+	if _, err := prober.Probe(context.TODO(), bucket, obj); err != nil {
+		t.Fatal(err)
+	}
+	if bucket.reads != reads {
+		t.Error("warmed object was read again")
+	}
+
+	if n := prober.Invalidate("other-bucket", obj.Name); n != 0 {
+		t.Errorf("wrong number of invalidated entries in other bucket\nwant 0\ngot  %d", n)
+	}
+	if n := prober.Invalidate("my-bucket", obj.Name); n != 1 {
+		t.Errorf("wrong number of invalidated entries\nwant 1\ngot  %d", n)
+	}
+	if _, err := prober.Probe(context.TODO(), bucket, obj); err != nil {
+		t.Fatal(err)
+	}
+	if bucket.reads == reads {
+		t.Error("invalidated object wasn't read again")
+	}
+}
@@ -0,0 +1,92 @@
+package vodmodule
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule/mp4"
+)
+
+type smilDocument struct {
+	XMLName xml.Name        `xml:"smil"`
+	Title   string          `xml:"title,attr,omitempty"`
+	Switch  []smilRendition `xml:"body>switch>*"`
+}
+
+type smilRendition struct {
+	XMLName        xml.Name
+	Src            string      `xml:"src,attr"`
+	SystemBitrate  int64       `xml:"system-bitrate,attr,omitempty"`
+	Width          int         `xml:"width,attr,omitempty"`
+	Height         int         `xml:"height,attr,omitempty"`
+	SystemLanguage string      `xml:"systemLanguage,attr,omitempty"`
+	Title          string      `xml:"title,attr,omitempty"`
+	Params         []smilParam `xml:"param"`
+}
+
+type smilParam struct {
+	Name      string `xml:"name,attr"`
+	Value     string `xml:"value,attr"`
+	ValueType string `xml:"valuetype,attr"`
+}
+
+// WriteSMIL writes the mapping as a SMIL file, in the format read by
+// Wowza-style packagers: a switch with a "video", "audio" or "textstream"
+// element for each sequence, referring to its clip path.
+//
+// Bitrates, resolutions and languages are included when known, with the
+// bitrates of each track in "videoBitrate" and "audioBitrate" params.
+// Mappings of live playlists and sequences with multiple clips are rejected
+// with ErrUnsupportedMapping.
+func (m *Mapping) WriteSMIL(w io.Writer) error {
+	if m.PlaylistType == PlaylistTypeLive {
+		return fmt.Errorf("%w: live playlists can't be written as SMIL", ErrUnsupportedMapping)
+	}
+	doc := smilDocument{Title: m.ID}
+	for i, seq := range m.Sequences {
+		if len(seq.Clips) != 1 || sequencePath(seq) == "" {
+			return fmt.Errorf("%w: sequence %d must have a single source clip", ErrUnsupportedMapping, i)
+		}
+		r := smilRendition{
+			Src:            seq.Clips[0].Path,
+			SystemLanguage: seq.Language,
+			Title:          seq.Label,
+		}
+		switch kind := sequenceKind(seq); kind {
+		case mp4.KindText:
+			r.XMLName.Local = "textstream"
+		default:
+			r.XMLName.Local = kind
+			r.SystemBitrate, _ = sequenceBandwidth(seq)
+			if kind == mp4.KindVideo {
+				r.Width, r.Height = seq.Width, seq.Height
+			}
+			bitrate := seq.Bitrate
+			if bitrate == nil {
+				bitrate = seq.AvgBitrate
+			}
+			if bitrate != nil && bitrate.Video > 0 {
+				r.Params = append(r.Params, smilDataParam("videoBitrate", bitrate.Video))
+			}
+			if bitrate != nil && bitrate.Audio > 0 {
+				r.Params = append(r.Params, smilDataParam("audioBitrate", bitrate.Audio))
+			}
+		}
+		doc.Switch = append(doc.Switch, r)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func smilDataParam(name string, value int64) smilParam {
+	return smilParam{Name: name, Value: fmt.Sprint(value), ValueType: "data"}
+}
@@ -0,0 +1,65 @@
+package vodmodule
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestWriteSMIL(t *testing.T) {
+	video := sourceSequence("mp4:videos/video1_720p.mp4")
+	video.Width, video.Height = 1280, 720
+	video.Bitrate = &Bitrate{Video: 2500000, Audio: 128000}
+	audio := sourceSequence("mp4:videos/video1_audio_es.m4a")
+	audio.Language, audio.Label = "spa", "Spanish"
+	audio.AvgBitrate = &Bitrate{Audio: 96000}
+	captions := sourceSequence("videos/video1_en.vtt")
+	captions.Language = "eng"
+	noBitrate := sourceSequence("mp4:videos/video1_480p.mp4")
+
+	m := Mapping{ID: "video1", Sequences: []Sequence{video, noBitrate, audio, captions}}
+	var buf bytes.Buffer
+	if err := m.WriteSMIL(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<smil title="video1">
+  <body>
+    <switch>
+      <video src="mp4:videos/video1_720p.mp4" system-bitrate="2628000" width="1280" height="720">
+        <param name="videoBitrate" value="2500000" valuetype="data"></param>
+        <param name="audioBitrate" value="128000" valuetype="data"></param>
+      </video>
+      <video src="mp4:videos/video1_480p.mp4"></video>
+      <audio src="mp4:videos/video1_audio_es.m4a" system-bitrate="96000" systemLanguage="spa" title="Spanish">
+        <param name="audioBitrate" value="96000" valuetype="data"></param>
+      </audio>
+      <textstream src="videos/video1_en.vtt" systemLanguage="eng"></textstream>
+    </switch>
+  </body>
+</smil>
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("wrong SMIL\nwant:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestWriteSMILUnsupported(t *testing.T) {
+	clip := Clip{Type: ClipTypeSource, Path: "/my-bucket/video1_720p.mp4"}
+	tests := []struct {
+		name    string
+		mapping Mapping
+	}{
+		{"live", Mapping{PlaylistType: PlaylistTypeLive, Sequences: []Sequence{{Clips: []Clip{clip}}}}},
+		{"multiple clips", Mapping{Sequences: []Sequence{{Clips: []Clip{clip, clip}}}}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := test.mapping.WriteSMIL(&bytes.Buffer{})
+			if !errors.Is(err, ErrUnsupportedMapping) {
+				t.Errorf("wrong error returned\nwant %v\ngot  %v", ErrUnsupportedMapping, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package vodmodule
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidRange is returned by Trim and TrimSequence when the range can't be
+// applied to the mapping.
+var ErrInvalidRange = errors.New("invalid range")
+
+// ClipRange is a time range in milliseconds, relative to the start of the
+// media. A zero To means the end of the media.
+type ClipRange struct {
+	From int64
+	To   int64
+}
+
+// IsZero returns whether the range covers the whole media.
+func (r ClipRange) IsZero() bool {
+	return r.From == 0 && r.To == 0
+}
+
+// validate checks the range against the length of the media, when known
+// (non-zero).
+func (r ClipRange) validate(length int64) error {
+	if r.From < 0 || r.To < 0 {
+		return fmt.Errorf("vodmodule: %w: negative offset", ErrInvalidRange)
+	}
+	if r.To > 0 && r.To <= r.From {
+		return fmt.Errorf("vodmodule: %w: clipTo (%d) must be after clipFrom (%d)", ErrInvalidRange, r.To, r.From)
+	}
+	if length > 0 && r.From >= length {
+		return fmt.Errorf("vodmodule: %w: clipFrom (%d) must be before the end of the media (%d)", ErrInvalidRange, r.From, length)
+	}
+	if length > 0 && r.To > length {
+		return fmt.Errorf("vodmodule: %w: clipTo (%d) must not be after the end of the media (%d)", ErrInvalidRange, r.To, length)
+	}
+	return nil
+}
+
+// Trim restricts all sequences of the mapping to the given range.
+//
+// In sequences with a single clip, the range is set in the clip, and
+// validated against the duration of the media, if known (see
+// Sequence.Duration). In sequences
+// with multiple clips, the range applies to the whole playlist: clips outside
+// of the range are removed, and the clips at the edges are trimmed, along
+// with Durations.
+func (m *Mapping) Trim(r ClipRange) error {
+	if r.IsZero() {
+		return nil
+	}
+	if m.PlaylistType == PlaylistTypeLive {
+		return fmt.Errorf("vodmodule: %w: live playlists can't be trimmed", ErrInvalidRange)
+	}
+	if len(m.Durations) == 0 {
+		for i := range m.Sequences {
+			if err := m.TrimSequence(i, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var total int64
+	for _, d := range m.Durations {
+		total += d
+	}
+	if err := r.validate(total); err != nil {
+		return err
+	}
+	to := r.To
+	if to == 0 {
+		to = total
+	}
+	var (
+		positions []int
+		ranges    []ClipRange
+		durations []int64
+		start     int64
+	)
+	for i, d := range m.Durations {
+		end := start + d
+		if end > r.From && start < to {
+			sub := ClipRange{From: max64(r.From-start, 0)}
+			if to < end {
+				sub.To = to - start
+			}
+			positions = append(positions, i)
+			ranges = append(ranges, sub)
+			durations = append(durations, min64(end, to)-start-sub.From)
+		}
+		start = end
+	}
+	for i := range m.Sequences {
+		seq := &m.Sequences[i]
+		clips := make([]Clip, 0, len(positions))
+		for j, pos := range positions {
+			clip := seq.Clips[pos]
+			if err := trimClip(&clip, ranges[j], m.Durations[pos]); err != nil {
+				return err
+			}
+			clips = append(clips, clip)
+		}
+		seq.Clips = clips
+	}
+	m.Durations = durations
+	return nil
+}
+
+// TrimSequence restricts the sequence at index i to the given range, which is
+// validated as in Trim. It's only supported in mappings with a single clip per
+// sequence.
+func (m *Mapping) TrimSequence(i int, r ClipRange) error {
+	if i < 0 || i >= len(m.Sequences) {
+		return fmt.Errorf("vodmodule: %w: sequence %d not found", ErrInvalidRange, i)
+	}
+	if r.IsZero() {
+		return nil
+	}
+	seq := &m.Sequences[i]
+	if len(m.Durations) > 0 || len(seq.Clips) != 1 {
+		return fmt.Errorf("vodmodule: %w: sequences with multiple clips can only be trimmed as a whole", ErrInvalidRange)
+	}
+	clip := &seq.Clips[0]
+	return trimClip(clip, r, seq.remaining())
+}
+
+// remaining returns the duration of the single clip of the sequence after its
+// current range, or zero if unknown.
+func (s *Sequence) remaining() int64 {
+	clip := s.Clips[0]
+	if clip.ClipTo > 0 {
+		return clip.ClipTo - clip.ClipFrom
+	}
+	duration := s.Duration
+	if duration == 0 && s.Media != nil {
+		duration = s.Media.DurationMillis()
+	}
+	if duration == 0 {
+		return 0
+	}
+	return duration - clip.ClipFrom
+}
+
+// trimClip applies the range to a source clip, relative to its current range.
+// length is the duration of the clip after the current range, or zero if
+// unknown.
+func trimClip(c *Clip, r ClipRange, length int64) error {
+	if r.IsZero() {
+		return nil
+	}
+	if c.Type != ClipTypeSource {
+		return fmt.Errorf("vodmodule: %w: %s clips can't be trimmed", ErrInvalidRange, c.Type)
+	}
+	if err := r.validate(length); err != nil {
+		return err
+	}
+	if r.To > 0 {
+		c.ClipTo = c.ClipFrom + r.To
+	}
+	c.ClipFrom += r.From
+	return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
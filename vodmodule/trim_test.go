@@ -0,0 +1,192 @@
+package vodmodule
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/NYTimes/gcs-helper/v3/vodmodule/mp4"
+)
+
+func TestMappingTrim(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    Mapping
+		r        ClipRange
+		expected Mapping
+	}{
+		{
+			"single clips",
+			Mapping{Sequences: []Sequence{
+				{Clips: []Clip{{Type: "source", Path: "/my-bucket/video_480p.mp4"}}},
+				{Clips: []Clip{{Type: "source", Path: "/my-bucket/video_720p.mp4"}}},
+			}},
+			ClipRange{From: 5000, To: 15000},
+			Mapping{Sequences: []Sequence{
+				{Clips: []Clip{{Type: "source", Path: "/my-bucket/video_480p.mp4", ClipFrom: 5000, ClipTo: 15000}}},
+				{Clips: []Clip{{Type: "source", Path: "/my-bucket/video_720p.mp4", ClipFrom: 5000, ClipTo: 15000}}},
+			}},
+		},
+		{
+			"relative to the current range",
+			Mapping{Sequences: []Sequence{
+				{Clips: []Clip{{Type: "source", Path: "/my-bucket/video.mp4", ClipFrom: 5000, ClipTo: 20000}}},
+			}},
+			ClipRange{From: 1000},
+			Mapping{Sequences: []Sequence{
+				{Clips: []Clip{{Type: "source", Path: "/my-bucket/video.mp4", ClipFrom: 6000, ClipTo: 20000}}},
+			}},
+		},
+		{
+			"multiple clips",
+			Mapping{
+				Durations: []int64{5000, 10000, 3000},
+				Sequences: []Sequence{
+					{Clips: []Clip{
+						{Type: "source", Path: "/my-bucket/preroll.mp4"},
+						{Type: "source", Path: "/my-bucket/video.mp4"},
+						{Type: "source", Path: "/my-bucket/bumper.mp4"},
+					}},
+				},
+			},
+			ClipRange{From: 6000, To: 12000},
+			Mapping{
+				Durations: []int64{6000},
+				Sequences: []Sequence{
+					{Clips: []Clip{{Type: "source", Path: "/my-bucket/video.mp4", ClipFrom: 1000, ClipTo: 7000}}},
+				},
+			},
+		},
+		{
+			"multiple clips until the end",
+			Mapping{
+				Durations: []int64{5000, 10000, 3000},
+				Sequences: []Sequence{
+					{Clips: []Clip{
+						{Type: "source", Path: "/my-bucket/preroll.mp4"},
+						{Type: "source", Path: "/my-bucket/video.mp4"},
+						{Type: "source", Path: "/my-bucket/bumper.mp4"},
+					}},
+				},
+			},
+			ClipRange{From: 14000},
+			Mapping{
+				Durations: []int64{1000, 3000},
+				Sequences: []Sequence{
+					{Clips: []Clip{
+						{Type: "source", Path: "/my-bucket/video.mp4", ClipFrom: 9000},
+						{Type: "source", Path: "/my-bucket/bumper.mp4"},
+					}},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			m := test.input
+			err := m.Trim(test.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m, test.expected) {
+				t.Errorf("wrong mapping\nwant %#v\ngot  %#v", test.expected, m)
+			}
+		})
+	}
+}
+
+func TestMappingTrimInvalidRange(t *testing.T) {
+	probed := &mp4.Info{Timescale: 1000, Duration: 10000}
+	tests := []struct {
+		name  string
+		input Mapping
+		r     ClipRange
+	}{
+		{
+			"negative offset",
+			Mapping{Sequences: []Sequence{{Clips: []Clip{{Type: "source", Path: "/video.mp4"}}}}},
+			ClipRange{From: -1},
+		},
+		{
+			"clipTo before clipFrom",
+			Mapping{Sequences: []Sequence{{Clips: []Clip{{Type: "source", Path: "/video.mp4"}}}}},
+			ClipRange{From: 5000, To: 4000},
+		},
+		{
+			"after probed duration",
+			Mapping{Sequences: []Sequence{{Media: probed, Clips: []Clip{{Type: "source", Path: "/video.mp4"}}}}},
+			ClipRange{From: 5000, To: 11000},
+		},
+		{
+			"after metadata duration",
+			Mapping{Sequences: []Sequence{{Duration: 10000, Clips: []Clip{{Type: "source", Path: "/video.mp4"}}}}},
+			ClipRange{From: 10000},
+		},
+		{
+			"after current range",
+			Mapping{Sequences: []Sequence{{Clips: []Clip{{Type: "source", Path: "/video.mp4", ClipFrom: 5000, ClipTo: 20000}}}}},
+			ClipRange{From: 15000},
+		},
+		{
+			"after durations",
+			Mapping{
+				Durations: []int64{5000, 5000},
+				Sequences: []Sequence{{Clips: []Clip{{Type: "source", Path: "/a.mp4"}, {Type: "source", Path: "/b.mp4"}}}},
+			},
+			ClipRange{From: 2000, To: 10001},
+		},
+		{
+			"live playlist",
+			Mapping{PlaylistType: "live", Sequences: []Sequence{{Clips: []Clip{{Type: "source", Path: "/video.mp4"}}}}},
+			ClipRange{From: 1000},
+		},
+		{
+			"dynamic clip",
+			Mapping{Sequences: []Sequence{{Clips: []Clip{{Type: "dynamic", ID: "ad-break"}}}}},
+			ClipRange{From: 1000},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := test.input.Trim(test.r)
+			if !errors.Is(err, ErrInvalidRange) {
+				t.Errorf("wrong error returned\nwant %v\ngot  %v", ErrInvalidRange, err)
+			}
+		})
+	}
+}
+
+func TestMappingTrimSequence(t *testing.T) {
+	m := Mapping{Sequences: []Sequence{
+		{Clips: []Clip{{Type: "source", Path: "/my-bucket/video.mp4"}}},
+		{Clips: []Clip{{Type: "source", Path: "/my-bucket/captions.vtt"}}},
+	}}
+	err := m.TrimSequence(1, ClipRange{From: 1000, To: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Clip{{Type: "source", Path: "/my-bucket/captions.vtt", ClipFrom: 1000, ClipTo: 2000}}
+	if !reflect.DeepEqual(m.Sequences[1].Clips, expected) {
+		t.Errorf("wrong clips\nwant %#v\ngot  %#v", expected, m.Sequences[1].Clips)
+	}
+	if m.Sequences[0].Clips[0].ClipFrom != 0 || m.Sequences[0].Clips[0].ClipTo != 0 {
+		t.Errorf("unexpected range in untouched sequence: %#v", m.Sequences[0].Clips[0])
+	}
+
+	m.Sequences[0].Duration = 5000
+	if err := m.TrimSequence(0, ClipRange{From: 1000, To: 6000}); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("wrong error for range after the duration\nwant %v\ngot  %v", ErrInvalidRange, err)
+	}
+	if err := m.TrimSequence(2, ClipRange{From: 1000}); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("wrong error for missing sequence\nwant %v\ngot  %v", ErrInvalidRange, err)
+	}
+	multi := Mapping{
+		Durations: []int64{5000, 5000},
+		Sequences: []Sequence{{Clips: []Clip{{Type: "source", Path: "/a.mp4"}, {Type: "source", Path: "/b.mp4"}}}},
+	}
+	if err := multi.TrimSequence(0, ClipRange{From: 1000}); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("wrong error for multiple clips\nwant %v\ngot  %v", ErrInvalidRange, err)
+	}
+}
@@ -1,19 +1,117 @@
 package vodmodule
 
+import "github.com/NYTimes/gcs-helper/v3/vodmodule/mp4"
+
+// Playlist types supported by nginx-vod-module.
+const (
+	PlaylistTypeVOD  = "vod"
+	PlaylistTypeLive = "live"
+)
+
+// Clip types supported by nginx-vod-module.
+const (
+	ClipTypeSource  = "source"
+	ClipTypeRate    = "rateFilter"
+	ClipTypeMix     = "mixFilter"
+	ClipTypeGain    = "gainFilter"
+	ClipTypeConcat  = "concat"
+	ClipTypeDynamic = "dynamic"
+)
+
 // Mapping represents the response expected by the vod-module.
 //
+// Fields that default to true in nginx-vod-module are pointers, so they're
+// only sent when explicitly set.
+//
 // See https://github.com/kaltura/nginx-vod-module#mapping-response-format.
 type Mapping struct {
-	Sequences []Sequence `json:"sequences"`
+	ID                          string         `json:"id,omitempty"`
+	PlaylistType                string         `json:"playlistType,omitempty"`
+	Discontinuity               *bool          `json:"discontinuity,omitempty"`
+	SegmentDuration             int64          `json:"segmentDuration,omitempty"`
+	ConsistentSequenceMediaInfo *bool          `json:"consistentSequenceMediaInfo,omitempty"`
+	InitialSegmentIndex         int64          `json:"initialSegmentIndex,omitempty"`
+	InitialClipIndex            int64          `json:"initialClipIndex,omitempty"`
+	PresentationEndTime         int64          `json:"presentationEndTime,omitempty"`
+	ExpirationTime              int64          `json:"expirationTime,omitempty"`
+	LiveWindowDuration          int64          `json:"liveWindowDuration,omitempty"`
+	ReferenceClipIndex          int64          `json:"referenceClipIndex,omitempty"`
+	ClipFrom                    int64          `json:"clipFrom,omitempty"`
+	Durations                   []int64        `json:"durations,omitempty"`
+	ClipTimes                   []int64        `json:"clipTimes,omitempty"`
+	SegmentBaseTime             int64          `json:"segmentBaseTime,omitempty"`
+	FirstClipTime               int64          `json:"firstClipTime,omitempty"`
+	Notifications               []Notification `json:"notifications,omitempty"`
+	Sequences                   []Sequence     `json:"sequences"`
+}
+
+// Notification represents an event that nginx-vod-module reports once the
+// playback reaches the given offset (in milliseconds).
+type Notification struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
 }
 
 // Sequence represents a list of media clips.
 type Sequence struct {
-	Clips []Clip `json:"clips"`
+	ID         string   `json:"id,omitempty"`
+	Language   string   `json:"language,omitempty"`
+	Label      string   `json:"label,omitempty"`
+	Bitrate    *Bitrate `json:"bitrate,omitempty"`
+	AvgBitrate *Bitrate `json:"avg_bitrate,omitempty"`
+	Clips      []Clip   `json:"clips"`
+
+	// Media contains the metadata of the first clip, when probed. It's not
+	// sent to nginx-vod-module.
+	Media *mp4.Info `json:"-"`
+
+	// Duration is the duration of the first clip in milliseconds, probed or
+	// from the "duration" metadata of the object (zero when unknown). It's
+	// not sent to nginx-vod-module.
+	Duration int64 `json:"-"`
+
+	// Width and Height are the resolution of the video, captured by the
+	// naming convention or probed. They're not sent to nginx-vod-module.
+	Width  int `json:"-"`
+	Height int `json:"-"`
+}
+
+// Bitrate contains bitrates in bits per second, by media type.
+type Bitrate struct {
+	Video int64 `json:"v,omitempty"`
+	Audio int64 `json:"a,omitempty"`
 }
 
-// Clip represents a single media file.
+// Clip represents a single media file or a filter applied to other clips.
+// The set of fields used depends on the type of the clip:
+//
+//   - source: Path, Tracks, ClipFrom, ClipTo, EncryptionKey and SourceType
+//   - rateFilter: Rate and Source
+//   - mixFilter: Sources
+//   - gainFilter: Gain and Source
+//   - concat: Paths or ClipIDs, Durations, BasePath, Offset, Tracks and
+//     ClipFrom
+//   - dynamic: ID
 type Clip struct {
 	Type string `json:"type"`
-	Path string `json:"path"`
+
+	Path          string `json:"path,omitempty"`
+	Tracks        string `json:"tracks,omitempty"`
+	ClipFrom      int64  `json:"clipFrom,omitempty"`
+	ClipTo        int64  `json:"clipTo,omitempty"`
+	EncryptionKey string `json:"encryptionKey,omitempty"`
+	SourceType    string `json:"sourceType,omitempty"`
+
+	Rate    float64 `json:"rate,omitempty"`
+	Gain    float64 `json:"gain,omitempty"`
+	Source  *Clip   `json:"source,omitempty"`
+	Sources []Clip  `json:"sources,omitempty"`
+
+	BasePath  string   `json:"basePath,omitempty"`
+	Paths     []string `json:"paths,omitempty"`
+	ClipIDs   []string `json:"clipIds,omitempty"`
+	Durations []int64  `json:"durations,omitempty"`
+	Offset    int64    `json:"offset,omitempty"`
+
+	ID string `json:"id,omitempty"`
 }
@@ -0,0 +1,184 @@
+package vodmodule
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// mappingExamples are based on the examples in nginx-vod-module's
+// documentation.
+var mappingExamples = []struct {
+	name string
+	json string
+}{
+	{
+		"simple mapping",
+		`{
+			"sequences": [
+				{"clips": [{"type": "source", "path": "/path/to/video.mp4"}]}
+			]
+		}`,
+	},
+	{
+		"adaptive set",
+		`{
+			"sequences": [
+				{"clips": [{"type": "source", "path": "/path/to/bitrate1.mp4"}]},
+				{"clips": [{"type": "source", "path": "/path/to/bitrate2.mp4"}]}
+			]
+		}`,
+	},
+	{
+		"playlist",
+		`{
+			"playlistType": "live",
+			"discontinuity": true,
+			"segmentBaseTime": 1451904060000,
+			"firstClipTime": 1451918060000,
+			"durations": [83000, 83000],
+			"sequences": [
+				{
+					"clips": [
+						{"type": "source", "path": "/path/to/video1.mp4"},
+						{"type": "source", "path": "/path/to/video2.mp4"}
+					]
+				}
+			]
+		}`,
+	},
+	{
+		"continuous live playlist",
+		`{
+			"id": "channel1",
+			"playlistType": "live",
+			"discontinuity": false,
+			"segmentDuration": 4000,
+			"initialSegmentIndex": 153,
+			"initialClipIndex": 171,
+			"segmentBaseTime": 1451904060000,
+			"firstClipTime": 1451918060000,
+			"clipTimes": [1451918060000, 1451918143000],
+			"durations": [83000, 83000],
+			"liveWindowDuration": 60000,
+			"notifications": [
+				{"id": "ad-start", "offset": 60000},
+				{"id": "ad-end", "offset": 90000}
+			],
+			"sequences": [
+				{
+					"clips": [
+						{"type": "source", "path": "/path/to/video1.mp4"},
+						{"type": "source", "path": "/path/to/video2.mp4"}
+					]
+				}
+			]
+		}`,
+	},
+	{
+		"filters",
+		`{
+			"sequences": [
+				{
+					"clips": [
+						{
+							"type": "mixFilter",
+							"sources": [
+								{
+									"type": "rateFilter",
+									"rate": 1.5,
+									"source": {
+										"type": "gainFilter",
+										"gain": 2.5,
+										"source": {"type": "source", "path": "/path/to/video.mp4", "tracks": "a1"}
+									}
+								},
+								{"type": "source", "path": "/path/to/video.mp4", "tracks": "v1"}
+							]
+						}
+					]
+				}
+			]
+		}`,
+	},
+	{
+		"sequence metadata, clipping, concat and dynamic clips",
+		`{
+			"id": "77071",
+			"playlistType": "vod",
+			"consistentSequenceMediaInfo": false,
+			"durations": [75000],
+			"sequences": [
+				{
+					"id": "video",
+					"bitrate": {"v": 900000, "a": 64000},
+					"avg_bitrate": {"v": 800000, "a": 64000},
+					"clips": [
+						{
+							"type": "concat",
+							"basePath": "/path/to/",
+							"paths": ["part1.mp4", "part2.mp4"],
+							"durations": [30000, 45000],
+							"tracks": "v1-a1"
+						}
+					]
+				},
+				{
+					"id": "captions-eng",
+					"language": "eng",
+					"label": "English",
+					"clips": [
+						{"type": "source", "path": "/path/to/captions.vtt", "clipFrom": 1000, "clipTo": 76000}
+					]
+				},
+				{
+					"clips": [{"type": "dynamic", "id": "ad-break"}]
+				}
+			]
+		}`,
+	},
+}
+
+func TestMappingJSONRoundTrip(t *testing.T) {
+	for _, example := range mappingExamples {
+		example := example
+		t.Run(example.name, func(t *testing.T) {
+			var m Mapping
+			decoder := json.NewDecoder(bytes.NewReader([]byte(example.json)))
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(&m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var expected, got interface{}
+			json.Unmarshal([]byte(example.json), &expected)
+			json.Unmarshal(data, &got)
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("wrong JSON after round trip\ndiff: %s", cmp.Diff(expected, got))
+			}
+		})
+	}
+}
+
+func TestMappingJSONOmitsUnsetFields(t *testing.T) {
+	m := Mapping{
+		Sequences: []Sequence{
+			{Clips: []Clip{{Type: ClipTypeSource, Path: "/my-bucket/video.mp4"}}},
+		},
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = `{"sequences":[{"clips":[{"type":"source","path":"/my-bucket/video.mp4"}]}]}`
+	if string(data) != expected {
+		t.Errorf("wrong JSON\nwant %s\ngot  %s", expected, data)
+	}
+}